@@ -0,0 +1,866 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// buildRoot is the directory holding one build directory per configuration,
+// build.dir in the settings
+var buildRoot = "build"
+
+// activeBuildFile records which build directory was built last
+const activeBuildFile = ".qs-active"
+
+// defaultConfig is the build type used until another one is selected
+const defaultConfig = "Debug"
+
+// buildConfigs lists the CMake build types qs knows about, by flag name
+var buildConfigs = map[string]string{
+	"debug":          "Debug",
+	"release":        "Release",
+	"relwithdebinfo": "RelWithDebInfo",
+	"minsizerel":     "MinSizeRel",
+}
+
+// buildOptions holds the arguments of 'qs build'
+type buildOptions struct {
+	Config      string           // CMake build type, e.g. "Debug"
+	Generator   string           // CMake generator given with -G, empty to auto-detect
+	Jobs        int              // number of parallel build jobs, 0 for one per CPU
+	Targets     []string         // targets to build, all of them if empty
+	Reconfigure bool             // run the configure step even if no input changed
+	CacheVars   []cacheEntry     // -D cache options, kept for later builds of the same directory
+	Clean       bool             // clean before building, for 'qs rebuild'
+	Diagnostics string           // also write compiler diagnostics as "json" or "sarif"
+	Toolchain   string           // toolchain name or file for cross builds, empty for the host compilers
+	Sanitizers  []string         // sanitizers to build with, e.g. "address", in their own build directory
+	Coverage    string           // coverage instrumentation, "gcov" or "llvm", for 'qs coverage'
+	Launcher    string           // compiler cache the compilers run through, detected when configuring
+	Timings     bool             // report the time spent in each build step
+	TraceFile   string           // also write the build steps as a Chrome trace
+	Compiler    compilerChoice   // compiler to build with instead of the default, in its own build directory
+	Matrix      []compilerChoice // compilers to build with side by side
+}
+
+// configureKey describes the options that affect the configure step, so a
+// change to any of them triggers a reconfigure
+func (opts buildOptions) configureKey(generator string) string {
+	key := fmt.Sprintf("config=%s\ngenerator=%s\ncompile_commands=ON\ntoolchain=%s\nsanitize=%s\ncoverage=%s\nlauncher=%s\n",
+		opts.Config, generator, opts.Toolchain, strings.Join(opts.Sanitizers, ","), opts.Coverage, opts.Launcher)
+	if opts.Timings && !isNinjaGenerator(generator) {
+		// Only generators other than Ninja need the timing wrapper configured.
+		// It runs qs by its path, so a moved or reinstalled qs reconfigures.
+		self, _ := os.Executable()
+		key += "timings=" + self + "\n"
+	}
+	if opts.Compiler.CXX != "" {
+		key += "compiler=" + opts.Compiler.C + ";" + opts.Compiler.CXX + "\n"
+	}
+	for _, entry := range opts.CacheVars {
+		key += "define=" + entry.String() + "\n"
+	}
+	return key
+}
+
+// Dir returns the build directory for these options, relative to the project
+// root. Each toolchain, compiler and set of sanitizers gets its own
+// directories, e.g. build/aarch64-release, build/clang-debug or
+// build/debug-asan-ubsan.
+func (opts buildOptions) Dir() string {
+	name := strings.ToLower(opts.Config)
+	if opts.Toolchain != "" {
+		name = toolchainName(opts.Toolchain) + "-" + name
+	}
+	if opts.Compiler.Name != "" {
+		name = opts.Compiler.Name + "-" + name
+	}
+	if len(opts.Sanitizers) > 0 {
+		name += "-" + sanitizerDirSuffix(opts.Sanitizers)
+	}
+	if opts.Coverage != "" {
+		name += "-coverage"
+	}
+	return filepath.Join(buildRoot, name)
+}
+
+// label describes the configuration in messages, e.g. "Release, toolchain aarch64"
+func (opts buildOptions) label() string {
+	label := opts.Config
+	if opts.Toolchain != "" {
+		label += ", toolchain " + toolchainName(opts.Toolchain)
+	}
+	if opts.Compiler.Name != "" {
+		label += ", " + opts.Compiler.Name
+	}
+	if len(opts.Sanitizers) > 0 {
+		label += ", sanitizers " + strings.Join(opts.Sanitizers, ",")
+	}
+	if opts.Coverage != "" {
+		label += ", coverage"
+	}
+	return label
+}
+
+// parseConfigFlag recognizes --debug, --release, --relwithdebinfo and --minsizerel
+func parseConfigFlag(arg string) (string, bool) {
+	if !strings.HasPrefix(arg, "--") {
+		return "", false
+	}
+	config, ok := buildConfigs[strings.ToLower(strings.TrimPrefix(arg, "--"))]
+	return config, ok
+}
+
+// lookupConfig resolves a build type name given with --config, case-insensitively
+func lookupConfig(name string) (string, error) {
+	if config, ok := buildConfigs[strings.ToLower(name)]; ok {
+		return config, nil
+	}
+	return "", fmt.Errorf("unknown build configuration '%s' (expected Debug, Release, RelWithDebInfo or MinSizeRel)", name)
+}
+
+// extractConfig removes configuration selection flags (--debug, --release,
+// --config <name>, ...) from args and returns the selected build type
+func extractConfig(args []string) (string, []string, error) {
+	config := ""
+	var rest []string
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		selected := ""
+
+		if value, ok := parseConfigFlag(arg); ok {
+			selected = value
+		} else if arg == "--config" || strings.HasPrefix(arg, "--config=") {
+			name := strings.TrimPrefix(arg, "--config=")
+			if arg == "--config" {
+				if i+1 >= len(args) {
+					return "", nil, fmt.Errorf("'--config' requires a value")
+				}
+				i++
+				name = args[i]
+			}
+			value, err := lookupConfig(name)
+			if err != nil {
+				return "", nil, err
+			}
+			selected = value
+		} else {
+			rest = append(rest, arg)
+			continue
+		}
+
+		if config != "" && config != selected {
+			return "", nil, fmt.Errorf("only one build configuration can be selected (got %s and %s)", config, selected)
+		}
+		config = selected
+	}
+
+	return config, rest, nil
+}
+
+// parseBuildArgs parses the arguments following 'qs build'
+func parseBuildArgs(args []string) (buildOptions, error) {
+	opts := buildOptions{
+		Generator:   settings.Build.Generator,
+		Jobs:        settings.Build.Jobs,
+		Diagnostics: settings.Build.Diagnostics,
+		Timings:     settings.Build.Timings,
+	}
+
+	config, rest, err := extractConfig(args)
+	if err != nil {
+		return opts, err
+	}
+	profile, rest, err := extractProfile(rest)
+	if err != nil {
+		return opts, err
+	}
+	// The builds of a matrix get every option the profile resolved to from
+	// the matrix, the default profile must not be applied on top
+	if profile == "" && os.Getenv(matrixEntryEnv) == "" {
+		profile = settings.Build.Profile
+	}
+	if profile != "" {
+		if err := applyProfile(&opts, profile); err != nil {
+			return opts, err
+		}
+		if config == "" {
+			config = settings.Profiles[profile].Config
+		}
+	}
+	profileCompiler := opts.Compiler
+
+	for i := 0; i < len(rest); i++ {
+		arg := rest[i]
+		name, value := arg, ""
+		switch {
+		case strings.HasPrefix(arg, "--") && strings.Contains(arg, "="):
+			parts := strings.SplitN(arg, "=", 2)
+			name, value = parts[0], parts[1]
+		case strings.HasPrefix(arg, "-G") && len(arg) > 2:
+			name, value = "-G", arg[2:]
+		case strings.HasPrefix(arg, "-j") && len(arg) > 2:
+			name, value = "-j", arg[2:]
+		case strings.HasPrefix(arg, "-D") && len(arg) > 2:
+			name, value = "-D", arg[2:]
+		}
+
+		switch name {
+		case "-D":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'-D' requires NAME=VALUE")
+				}
+				i++
+				value = rest[i]
+			}
+			entry, err := parseCacheDefinition(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.CacheVars = mergeCacheEntries(opts.CacheVars, []cacheEntry{entry})
+		case "--diagnostics":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'--diagnostics' requires a format (json or sarif)")
+				}
+				i++
+				value = rest[i]
+			}
+			if _, ok := diagnosticFormats[value]; !ok {
+				return opts, fmt.Errorf("unknown diagnostics format '%s' (expected json or sarif)", value)
+			}
+			opts.Diagnostics = value
+		case "--sanitize":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'--sanitize' requires a list of sanitizers, e.g. address,undefined")
+				}
+				i++
+				value = rest[i]
+			}
+			names, err := parseSanitizers(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Sanitizers = names
+		case "--toolchain":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'--toolchain' requires a toolchain name")
+				}
+				i++
+				value = rest[i]
+			}
+			if !fileExists(toolchainFile(value)) {
+				return opts, fmt.Errorf("toolchain '%s' not found (expected %s), create it with 'qs toolchain new %s'", value, toolchainFile(value), toolchainName(value))
+			}
+			opts.Toolchain = value
+		case "--compiler", "--matrix":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'%s' requires a compiler, e.g. gcc, clang or a path", name)
+				}
+				i++
+				value = rest[i]
+			}
+			if name == "--compiler" {
+				compiler, err := resolveCompiler(value)
+				if err != nil {
+					return opts, err
+				}
+				opts.Compiler = compiler
+				continue
+			}
+			matrix, err := parseMatrix(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.Matrix = matrix
+		case "-G", "--generator", "-j", "--parallel":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'%s' requires a value", name)
+				}
+				i++
+				value = rest[i]
+			}
+			if name == "-G" || name == "--generator" {
+				opts.Generator = value
+				continue
+			}
+			jobs, err := strconv.Atoi(value)
+			if err != nil || jobs < 1 {
+				return opts, fmt.Errorf("invalid number of jobs '%s'", value)
+			}
+			opts.Jobs = jobs
+		case "--timings":
+			opts.Timings = true
+		case "--trace":
+			if value == "" {
+				if i+1 >= len(rest) {
+					return opts, fmt.Errorf("'--trace' requires a file name")
+				}
+				i++
+				value = rest[i]
+			}
+			opts.Timings = true
+			opts.TraceFile = value
+		case "--reconfigure":
+			opts.Reconfigure = true
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, fmt.Errorf("unknown option '%s'", arg)
+			}
+			opts.Targets = append(opts.Targets, arg)
+		}
+	}
+
+	if len(opts.Matrix) > 0 {
+		if opts.Compiler == profileCompiler {
+			// The matrix replaces the compiler of the profile
+			opts.Compiler = compilerChoice{}
+		}
+		switch {
+		case opts.Compiler.CXX != "":
+			return opts, fmt.Errorf("'--matrix' and '--compiler' cannot be combined")
+		case opts.Toolchain != "":
+			return opts, fmt.Errorf("'--matrix' and '--toolchain' cannot be combined, the toolchain picks the compilers")
+		case opts.TraceFile != "":
+			return opts, fmt.Errorf("'--trace' cannot be combined with '--matrix'")
+		}
+	}
+	if opts.Compiler.CXX != "" && opts.Toolchain != "" {
+		return opts, fmt.Errorf("'--compiler' and '--toolchain' cannot be combined, the toolchain picks the compilers")
+	}
+
+	opts.Config = config
+	if opts.Config == "" {
+		opts.Config = lastBuiltConfig()
+	}
+	return opts, nil
+}
+
+// lastBuiltConfig returns the configuration of the most recently built build
+// directory, so qs keeps building whatever was built last, or the default
+// configuration for a project that was not built yet
+func lastBuiltConfig() string {
+	config := defaultConfig
+	if settings.Build.Config != "" {
+		config = settings.Build.Config
+	}
+	if dir := activeBuildDir(); dir != "" {
+		if cached := readCacheValue(filepath.Join(dir, "CMakeCache.txt"), "CMAKE_BUILD_TYPE"); cached != "" {
+			config = cached
+		}
+	}
+	return config
+}
+
+// extractBuildSelection removes the options that select a build directory
+// from args: the configuration flags, --sanitize, --toolchain and --compiler
+func extractBuildSelection(args []string) (buildOptions, []string, error) {
+	var sel buildOptions
+	config, args, err := extractConfig(args)
+	if err != nil {
+		return sel, nil, err
+	}
+	sel.Config = config
+
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value := arg, ""
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			parts := strings.SplitN(arg, "=", 2)
+			name, value = parts[0], parts[1]
+		}
+		if name != "--sanitize" && name != "--toolchain" && name != "--compiler" {
+			rest = append(rest, arg)
+			continue
+		}
+		if value == "" {
+			if i+1 >= len(args) {
+				return sel, nil, fmt.Errorf("'%s' requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+
+		switch name {
+		case "--sanitize":
+			names, err := parseSanitizers(value)
+			if err != nil {
+				return sel, nil, err
+			}
+			sel.Sanitizers = names
+		case "--toolchain":
+			sel.Toolchain = value
+		case "--compiler":
+			compiler, err := resolveCompiler(value)
+			if err != nil {
+				return sel, nil, err
+			}
+			sel.Compiler = compiler
+		}
+	}
+	return sel, rest, nil
+}
+
+// selectsVariant reports whether a toolchain, compiler or sanitizers are
+// selected, which build in directories of their own
+func (opts buildOptions) selectsVariant() bool {
+	return opts.Toolchain != "" || opts.Compiler.Name != "" || len(opts.Sanitizers) > 0
+}
+
+// selectionArgs returns the 'qs build' options that build the selected
+// build directory
+func (opts buildOptions) selectionArgs() string {
+	args := []string{"--" + strings.ToLower(opts.Config)}
+	if opts.Toolchain != "" {
+		args = append(args, "--toolchain", toolchainName(opts.Toolchain))
+	}
+	if opts.Compiler.Spec != "" {
+		args = append(args, "--compiler", opts.Compiler.Spec)
+	}
+	if len(opts.Sanitizers) > 0 {
+		args = append(args, "--sanitize", strings.Join(opts.Sanitizers, ","))
+	}
+	return strings.Join(args, " ")
+}
+
+// activeBuildDir returns the most recently built build directory, relative to
+// the project root, or an empty string if the project has not been built.
+// Build directories created by older versions of qs are used as a fallback.
+func activeBuildDir() string {
+	if data, err := os.ReadFile(filepath.Join(buildRoot, activeBuildFile)); err == nil {
+		dir := filepath.Join(buildRoot, strings.TrimSpace(string(data)))
+		if fileExists(filepath.Join(dir, "CMakeCache.txt")) {
+			return dir
+		}
+	}
+	if fileExists(filepath.Join(buildRoot, "CMakeCache.txt")) {
+		return buildRoot
+	}
+	return ""
+}
+
+// setActiveBuildDir records dir as the most recently built build directory
+func setActiveBuildDir(dir string) {
+	rel, err := filepath.Rel(buildRoot, dir)
+	if err != nil {
+		return
+	}
+	err = os.WriteFile(filepath.Join(buildRoot, activeBuildFile), []byte(rel+"\n"), 0644)
+	if err != nil {
+		fmt.Printf("Warning: Could not record the active build directory: %s\n", err)
+	}
+}
+
+// selectBuildDir returns the build directory of the selected configuration,
+// toolchain, compiler and sanitizers, or the most recently built one if
+// nothing is selected. A configuration on its own also picks the most
+// recently built directory when that has the configuration, e.g. after
+// 'qs build --debug --sanitize address', 'qs run --debug' runs that build.
+func selectBuildDir(sel buildOptions) (string, error) {
+	active := activeBuildDir()
+	if !sel.selectsVariant() {
+		if sel.Config == "" {
+			if active == "" {
+				return "", fmt.Errorf("build directory not found, run 'qs build' to build the project first")
+			}
+			return active, nil
+		}
+		if active != "" && readCacheValue(filepath.Join(active, "CMakeCache.txt"), "CMAKE_BUILD_TYPE") == sel.Config {
+			return active, nil
+		}
+	}
+
+	if sel.Config == "" {
+		sel.Config = lastBuiltConfig()
+		if !fileExists(filepath.Join(sel.Dir(), "CMakeCache.txt")) {
+			// Use the only configuration built with these options, if there is one
+			var built []string
+			for _, config := range buildConfigs {
+				variant := sel
+				variant.Config = config
+				if fileExists(filepath.Join(variant.Dir(), "CMakeCache.txt")) {
+					built = append(built, config)
+				}
+			}
+			if len(built) == 1 {
+				sel.Config = built[0]
+			}
+		}
+	}
+	dir := sel.Dir()
+	if !fileExists(filepath.Join(dir, "CMakeCache.txt")) {
+		return "", fmt.Errorf("the %s configuration has not been built yet, run 'qs build %s' first", sel.label(), sel.selectionArgs())
+	}
+	return dir, nil
+}
+
+// executablesDir returns where a build directory keeps its executables
+func executablesDir(buildDir string) string {
+	// Check if bin directory exists (standard layout)
+	binDir := filepath.Join(buildDir, "bin")
+	if isDir(binDir) {
+		// Multi-config generators add a directory per configuration
+		buildType := readCacheValue(filepath.Join(buildDir, "CMakeCache.txt"), "CMAKE_BUILD_TYPE")
+		if buildType != "" && isDir(filepath.Join(binDir, buildType)) {
+			return filepath.Join(binDir, buildType)
+		}
+		return binDir
+	}
+	// Fall back to just the build directory
+	return buildDir
+}
+
+// findExecutables lists the executable files in a directory
+func findExecutables(dir string) []string {
+	var executables []string
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return executables
+	}
+
+	for _, file := range files {
+		// Skip directories and files starting with "."
+		if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
+			continue
+		}
+
+		// On Unix systems, check if file is executable
+		fileInfo, err := os.Stat(filepath.Join(dir, file.Name()))
+		if err != nil {
+			continue
+		}
+
+		// Check if file has execute permission
+		if fileInfo.Mode()&0111 != 0 {
+			executables = append(executables, file.Name())
+		}
+	}
+
+	return executables
+}
+
+// warnUnknownTargets warns about target names the project model does not
+// know and suggests close matches. The model only sees the targets qs can
+// read from the CMakeLists.txt files, so the build goes ahead and CMake has
+// the final say.
+func warnUnknownTargets(targets []string) {
+	if len(targets) == 0 {
+		return
+	}
+
+	model, err := loadProject()
+	if err != nil {
+		return
+	}
+	names := append(model.targetNames(), builtinTargets...)
+
+	for _, target := range targets {
+		if containsWord(names, target) {
+			continue
+		}
+		fmt.Printf("Warning: '%s' is not a target qs found in the CMakeLists.txt files\n", target)
+		if suggestions := suggestTargets(target, names); len(suggestions) > 0 {
+			fmt.Println("Did you mean:")
+			for _, suggestion := range suggestions {
+				fmt.Printf("  qs build %s\n", suggestion)
+			}
+		}
+	}
+}
+
+// detectGenerator picks the CMake generator for a new build directory:
+// Ninja when it is installed, otherwise Makefiles. An empty result lets
+// CMake use its platform default.
+func detectGenerator() string {
+	if _, err := exec.LookPath("ninja"); err == nil {
+		return "Ninja"
+	}
+	if runtime.GOOS != "windows" {
+		if _, err := exec.LookPath("make"); err == nil {
+			return "Unix Makefiles"
+		}
+	}
+	return ""
+}
+
+// isMultiConfigGenerator reports whether a generator builds several
+// configurations from one build directory
+func isMultiConfigGenerator(generator string) bool {
+	return strings.Contains(generator, "Multi-Config") ||
+		strings.HasPrefix(generator, "Visual Studio") ||
+		generator == "Xcode"
+}
+
+// resolveGenerator returns the generator to use for a build directory and
+// whether it still has to be passed to CMake. A configured build directory
+// keeps the generator it was created with; asking for a different one is an
+// error since CMake cannot switch generators in place.
+func resolveGenerator(buildDir string, requested string) (string, bool, error) {
+	cached := readCacheValue(filepath.Join(buildDir, "CMakeCache.txt"), "CMAKE_GENERATOR")
+	if cached != "" {
+		if requested != "" && !strings.EqualFold(requested, cached) {
+			return "", false, fmt.Errorf("%s was configured with the '%s' generator, remove it to switch to '%s'", relativePath(buildDir), cached, requested)
+		}
+		return cached, false, nil
+	}
+	if requested != "" {
+		return requested, true, nil
+	}
+	generator := detectGenerator()
+	return generator, generator != "", nil
+}
+
+// relativePath shortens an absolute path below the current directory for messages
+func relativePath(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+// configureProject creates the build directory for the selected
+// configuration and runs cmake in it, unless none of its inputs changed. It
+// returns the absolute build directory and its generator.
+func configureProject(opts buildOptions) (string, string, bool) {
+	// Check for CMakeLists.txt
+	if _, err := os.Stat("CMakeLists.txt"); os.IsNotExist(err) {
+		fmt.Println("Error: CMakeLists.txt not found in the current directory.")
+		fmt.Println("Run 'qs init' to create a new CMake project.")
+		return "", "", false
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("Error getting current directory: %s\n", err)
+		return "", "", false
+	}
+
+	// Cross compilers are not checked, the toolchain file picks them
+	if len(opts.Sanitizers) > 0 && opts.Toolchain == "" {
+		compiler, found := findCXXCompiler()
+		if opts.Compiler.CXX != "" {
+			compiler, found = identifyCompiler([]string{opts.Compiler.CXX}), true
+		}
+		if found {
+			if err := checkSanitizerSupport(compiler, opts.Sanitizers); err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return "", "", false
+			}
+		}
+	}
+
+	buildDir := filepath.Join(cwd, opts.Dir())
+
+	// Create build directory if it doesn't exist
+	if _, err := os.Stat(buildDir); os.IsNotExist(err) {
+		fmt.Printf("Creating build directory %s...\n", opts.Dir())
+		err := os.MkdirAll(buildDir, 0755)
+		if err != nil {
+			fmt.Printf("Error creating build directory: %s\n", err)
+			return "", "", false
+		}
+	}
+
+	generator, passGenerator, err := resolveGenerator(buildDir, opts.Generator)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return "", "", false
+	}
+
+	launcher, err := detectLauncher()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return "", "", false
+	}
+	opts.Launcher = launcher
+
+	// Run cmake, unless none of its inputs changed since the last configure
+	state := loadBuildState(buildDir)
+	opts.CacheVars = mergeCacheEntries(state.CacheVars, opts.CacheVars)
+	inputsHash := hashCMakeInputs(opts.configureKey(generator))
+	configured := fileExists(filepath.Join(buildDir, "CMakeCache.txt"))
+	if configured && !opts.Reconfigure && state.InputsHash == inputsHash {
+		fmt.Printf("CMake inputs unchanged, skipping configure (%s)\n", opts.label())
+	} else {
+		fmt.Printf("Running CMake (%s)...\n", opts.label())
+		cmakeArgs := []string{cwd, "-DCMAKE_BUILD_TYPE=" + opts.Config, "-DCMAKE_EXPORT_COMPILE_COMMANDS=ON"}
+		if passGenerator {
+			cmakeArgs = append(cmakeArgs, "-G", generator)
+		}
+		if opts.Toolchain != "" {
+			toolchain, _ := filepath.Abs(toolchainFile(opts.Toolchain))
+			cmakeArgs = append(cmakeArgs, "-DCMAKE_TOOLCHAIN_FILE="+toolchain)
+		}
+		if opts.Compiler.C != "" {
+			cmakeArgs = append(cmakeArgs, "-DCMAKE_C_COMPILER="+opts.Compiler.C)
+		}
+		if opts.Compiler.CXX != "" {
+			cmakeArgs = append(cmakeArgs, "-DCMAKE_CXX_COMPILER="+opts.Compiler.CXX)
+		}
+		definitions := opts.CacheVars
+		if len(opts.Sanitizers) > 0 {
+			definitions = appendCompileFlags(definitions, sanitizerFlags(opts.Sanitizers))
+		}
+		if opts.Coverage != "" {
+			definitions = appendCompileFlags(definitions, coverageFlags(opts.Coverage))
+		}
+		for _, entry := range definitions {
+			cmakeArgs = append(cmakeArgs, "-D"+entry.String())
+		}
+		var wrapper []string
+		if opts.Timings && !isNinjaGenerator(generator) {
+			wrapper = timingWrapper(buildDir)
+		}
+		cmakeArgs = append(cmakeArgs, launcherArgs(opts.Launcher, wrapper, opts.CacheVars)...)
+		cmakeCmd := exec.Command("cmake", cmakeArgs...)
+		cmakeCmd.Dir = buildDir
+		cmakeCmd.Stdout = os.Stdout
+		cmakeCmd.Stderr = os.Stderr
+
+		err = cmakeCmd.Run()
+		if err != nil {
+			fmt.Printf("Error running cmake: %s\n", err)
+			return "", "", false
+		}
+
+		state.InputsHash = inputsHash
+		state.CacheVars = opts.CacheVars
+		state.Sanitizers = opts.Sanitizers
+		state.Launcher = opts.Launcher
+		if err := saveBuildState(buildDir, state); err != nil {
+			fmt.Printf("Warning: Could not save build state: %s\n", err)
+		}
+	}
+	// The builds of a matrix run side by side, the matrix picks the active one
+	if os.Getenv(matrixEntryEnv) == "" {
+		setActiveBuildDir(opts.Dir())
+		linkCompileCommands(buildDir)
+	}
+
+	if generator == "" {
+		generator = readCacheValue(filepath.Join(buildDir, "CMakeCache.txt"), "CMAKE_GENERATOR")
+	}
+	return buildDir, generator, true
+}
+
+// buildProject creates the build directory for the selected configuration,
+// runs cmake and builds it with cmake --build
+func buildProject(opts buildOptions) bool {
+	if len(opts.Matrix) > 0 {
+		return buildMatrix(opts)
+	}
+
+	buildDir, generator, ok := configureProject(opts)
+	if !ok {
+		return false
+	}
+
+	// Build through CMake so any generator works
+	jobs := opts.Jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU()
+	}
+	if len(opts.Targets) > 0 {
+		fmt.Printf("Building %s with %s (%d jobs)...\n", strings.Join(opts.Targets, ", "), generator, jobs)
+	} else {
+		fmt.Printf("Building with %s (%d jobs)...\n", generator, jobs)
+	}
+	buildArgs := []string{"--build", buildDir, "--parallel", strconv.Itoa(jobs)}
+	if isMultiConfigGenerator(generator) {
+		buildArgs = append(buildArgs, "--config", opts.Config)
+	}
+	for _, target := range opts.Targets {
+		buildArgs = append(buildArgs, "--target", target)
+	}
+	if opts.Clean {
+		buildArgs = append(buildArgs, "--clean-first")
+	}
+
+	// Pass the output through while collecting compiler diagnostics from it
+	diagnostics := newDiagnosticCollector(buildDir)
+	output := io.MultiWriter(os.Stdout, diagnostics)
+	buildCmd := exec.Command("cmake", buildArgs...)
+	buildCmd.Stdout = output
+	buildCmd.Stderr = output
+
+	launcher := loadBuildState(buildDir).Launcher
+	hits, misses, haveStats := launcherStats(launcher)
+	var timings timingsMark
+	if opts.Timings {
+		timings = markTimings(buildDir, generator)
+	}
+
+	err := buildCmd.Run()
+	diagnostics.printSummary()
+	if haveStats {
+		printLauncherStats(launcher, hits, misses)
+	}
+	if opts.Timings {
+		reportTimings(timings, opts.TraceFile)
+	}
+	if opts.Diagnostics != "" {
+		if path, err := diagnostics.writeDiagnostics(opts.Diagnostics); err != nil {
+			fmt.Printf("Error writing diagnostics: %s\n", err)
+		} else {
+			fmt.Printf("Diagnostics written to %s\n", relativePath(path))
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error building project: %s\n", err)
+		return false
+	}
+
+	fmt.Println("Build completed successfully!")
+	return true
+}
+
+// testProject runs the project's tests with ctest in the selected build directory
+func testProject(sel buildOptions, ctestArgs []string) {
+	buildDir, err := selectBuildDir(sel)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+
+	fmt.Printf("Running tests in %s...\n", buildDir)
+	if err := runCTest(buildDir, ctestArgs, sanitizerEnv(buildDir)); err != nil {
+		fmt.Printf("Error running tests: %s\n", err)
+	}
+}
+
+// runCTest runs ctest in buildDir with the given environment, or the current
+// one if env is nil
+func runCTest(buildDir string, ctestArgs []string, env []string) error {
+	args := []string{"--output-on-failure"}
+	if buildType := readCacheValue(filepath.Join(buildDir, "CMakeCache.txt"), "CMAKE_BUILD_TYPE"); buildType != "" {
+		// Needed by multi-config generators, harmless otherwise
+		args = append(args, "-C", buildType)
+	}
+	args = append(args, ctestArgs...)
+
+	cmd := exec.Command("ctest", args...)
+	cmd.Dir = buildDir
+	cmd.Env = env
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// cacheEntry is a CMake cache variable, e.g. -DNAME:TYPE=VALUE
+type cacheEntry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type,omitempty"`
+	Value string `json:"value"`
+}
+
+// String formats the entry as it is passed to cmake -D
+func (e cacheEntry) String() string {
+	if e.Type == "" {
+		return e.Name + "=" + e.Value
+	}
+	return e.Name + ":" + e.Type + "=" + e.Value
+}
+
+// cacheTypes lists the CMake cache entry types
+var cacheTypes = []string{"BOOL", "PATH", "FILEPATH", "STRING", "INTERNAL", "STATIC", "UNINITIALIZED"}
+
+// parseCacheDefinition parses NAME[:TYPE]=VALUE as accepted by cmake -D and
+// normalizes the value
+func parseCacheDefinition(definition string) (cacheEntry, error) {
+	entry, err := splitCacheDefinition(definition)
+	if err != nil {
+		return entry, err
+	}
+	return normalizeCacheValue(entry)
+}
+
+// splitCacheDefinition splits NAME[:TYPE]=VALUE, keeping the value as it is
+func splitCacheDefinition(definition string) (cacheEntry, error) {
+	var entry cacheEntry
+
+	index := strings.Index(definition, "=")
+	if index <= 0 {
+		return entry, fmt.Errorf("invalid cache definition '%s' (expected NAME=VALUE or NAME:TYPE=VALUE)", definition)
+	}
+	entry.Name, entry.Value = definition[:index], definition[index+1:]
+
+	if colon := strings.Index(entry.Name, ":"); colon >= 0 {
+		entry.Name, entry.Type = entry.Name[:colon], strings.ToUpper(entry.Name[colon+1:])
+		if !containsWord(cacheTypes, entry.Type) {
+			return entry, fmt.Errorf("unknown cache type '%s' (expected BOOL, PATH, FILEPATH or STRING)", entry.Type)
+		}
+	}
+	if entry.Name == "" {
+		return entry, fmt.Errorf("invalid cache definition '%s': missing name", definition)
+	}
+	return entry, nil
+}
+
+// normalizeCacheValue checks a value against its type. Booleans are
+// normalized to ON/OFF; paths are made absolute since CMake resolves them
+// relative to the build directory otherwise.
+func normalizeCacheValue(entry cacheEntry) (cacheEntry, error) {
+	switch entry.Type {
+	case "BOOL":
+		switch strings.ToUpper(entry.Value) {
+		case "ON", "TRUE", "YES", "Y", "1":
+			entry.Value = "ON"
+		case "OFF", "FALSE", "NO", "N", "0", "":
+			entry.Value = "OFF"
+		default:
+			return entry, fmt.Errorf("invalid BOOL value '%s' for %s (expected ON or OFF)", entry.Value, entry.Name)
+		}
+	case "PATH", "FILEPATH":
+		if entry.Value != "" && !filepath.IsAbs(entry.Value) {
+			if abs, err := filepath.Abs(entry.Value); err == nil {
+				entry.Value = abs
+			}
+		}
+	}
+	return entry, nil
+}
+
+// readCache parses the entries of a CMakeCache.txt file. Values are kept as
+// CMake stored them.
+func readCache(cachePath string) ([]cacheEntry, error) {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []cacheEntry
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		entry, err := splitCacheDefinition(line)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// findCacheEntry looks up a cache entry by name
+func findCacheEntry(entries []cacheEntry, name string) (cacheEntry, bool) {
+	for _, entry := range entries {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return cacheEntry{}, false
+}
+
+// writeCacheEntry sets an entry in CMakeCache.txt, replacing an existing one
+func writeCacheEntry(cachePath string, entry cacheEntry) error {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, entry.Name+":") {
+			lines[i] = entry.String()
+			return os.WriteFile(cachePath, []byte(strings.Join(lines, "\n")), 0644)
+		}
+	}
+
+	content := strings.TrimRight(string(data), "\n") + "\n\n//Set by qs config\n" + entry.String() + "\n"
+	return os.WriteFile(cachePath, []byte(content), 0644)
+}
+
+// removeCacheEntry deletes an entry and its help comment from CMakeCache.txt
+func removeCacheEntry(cachePath string, name string) (bool, error) {
+	data, err := os.ReadFile(cachePath)
+	if err != nil {
+		return false, err
+	}
+
+	lines := strings.Split(string(data), "\n")
+	var result []string
+	removed := false
+	for _, line := range lines {
+		if strings.HasPrefix(line, name+":") || strings.HasPrefix(line, name+"-ADVANCED:") {
+			removed = true
+			// Drop the help comment above the entry
+			for len(result) > 0 && strings.HasPrefix(result[len(result)-1], "//") {
+				result = result[:len(result)-1]
+			}
+			continue
+		}
+		result = append(result, line)
+	}
+	if !removed {
+		return false, nil
+	}
+	return true, os.WriteFile(cachePath, []byte(strings.Join(result, "\n")), 0644)
+}
+
+// mergeCacheEntries returns base with the entries of overrides replacing or
+// extending it, sorted by name
+func mergeCacheEntries(base []cacheEntry, overrides []cacheEntry) []cacheEntry {
+	merged := map[string]cacheEntry{}
+	for _, entry := range base {
+		merged[entry.Name] = entry
+	}
+	for _, entry := range overrides {
+		merged[entry.Name] = entry
+	}
+
+	var result []cacheEntry
+	for _, entry := range merged {
+		result = append(result, entry)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+// appendCompileFlags adds flags to the C and C++ compile flags and the linker
+// flags in the cache entries, after any flags the user set there
+func appendCompileFlags(entries []cacheEntry, flags string) []cacheEntry {
+	var result []cacheEntry
+	for _, name := range []string{"CMAKE_C_FLAGS", "CMAKE_CXX_FLAGS", "CMAKE_EXE_LINKER_FLAGS", "CMAKE_SHARED_LINKER_FLAGS"} {
+		entry := cacheEntry{Name: name, Type: "STRING", Value: flags}
+		if existing, ok := findCacheEntry(entries, name); ok && existing.Value != "" {
+			entry.Value = existing.Value + " " + flags
+		}
+		result = append(result, entry)
+	}
+	return mergeCacheEntries(entries, result)
+}
+
+// configCommand implements 'qs config get|set|unset|list'
+func configCommand(args []string) {
+	sel, args, err := extractBuildSelection(args)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	if len(args) == 0 {
+		fmt.Println("Error: usage is 'qs config get|set|unset|list ...'")
+		return
+	}
+
+	buildDir, err := selectBuildDir(sel)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	cachePath := filepath.Join(buildDir, "CMakeCache.txt")
+	entries, err := readCache(cachePath)
+	if err != nil {
+		fmt.Printf("Error reading %s: %s\n", cachePath, err)
+		return
+	}
+
+	switch args[0] {
+	case "list":
+		showAll := len(args) > 1 && args[1] == "--all"
+		advanced := map[string]bool{}
+		for _, entry := range entries {
+			if strings.HasSuffix(entry.Name, "-ADVANCED") {
+				advanced[strings.TrimSuffix(entry.Name, "-ADVANCED")] = true
+			}
+		}
+		fmt.Printf("Cache entries in %s:\n", buildDir)
+		for _, entry := range entries {
+			if !showAll && (entry.Type == "INTERNAL" || entry.Type == "STATIC" || advanced[entry.Name]) {
+				continue
+			}
+			fmt.Printf("  %s\n", entry)
+		}
+		if !showAll {
+			fmt.Println("Use 'qs config list --all' to include advanced and internal entries.")
+		}
+
+	case "get":
+		if len(args) < 2 {
+			fmt.Println("Error: usage is 'qs config get <name>'")
+			return
+		}
+		entry, ok := findCacheEntry(entries, args[1])
+		if !ok {
+			fmt.Printf("Error: '%s' is not set in %s\n", args[1], cachePath)
+			return
+		}
+		fmt.Println(entry.Value)
+
+	case "set":
+		if len(args) < 2 {
+			fmt.Println("Error: usage is 'qs config set <name>[:<type>] <value>'")
+			return
+		}
+		// Accept both 'set NAME[:TYPE]=VALUE' and 'set NAME[:TYPE] VALUE'
+		definition := args[1]
+		if len(args) > 2 {
+			definition += "=" + strings.Join(args[2:], " ")
+		}
+		entry, err := parseCacheDefinition(definition)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+
+		// Keep the type CMake gave an existing entry, and check the value against it
+		if existing, ok := findCacheEntry(entries, entry.Name); ok {
+			if entry.Type != "" && existing.Type != entry.Type && existing.Type != "UNINITIALIZED" {
+				fmt.Printf("Error: %s is a %s entry, not %s\n", entry.Name, existing.Type, entry.Type)
+				return
+			}
+			if entry.Type == "" {
+				entry.Type = existing.Type
+			}
+		}
+		if entry.Type == "" {
+			entry.Type = "STRING"
+		}
+		if entry.Type == "INTERNAL" || entry.Type == "STATIC" {
+			fmt.Printf("Error: %s is an %s entry managed by CMake\n", entry.Name, entry.Type)
+			return
+		}
+		entry, err = normalizeCacheValue(entry)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if entry.Type == "PATH" && !isDir(entry.Value) {
+			fmt.Printf("Warning: directory '%s' does not exist\n", entry.Value)
+		}
+		if entry.Type == "FILEPATH" && !fileExists(entry.Value) {
+			fmt.Printf("Warning: file '%s' does not exist\n", entry.Value)
+		}
+
+		if err := writeCacheEntry(cachePath, entry); err != nil {
+			fmt.Printf("Error updating %s: %s\n", cachePath, err)
+			return
+		}
+		// Remember it so the next configure passes it again
+		state := loadBuildState(buildDir)
+		state.CacheVars = mergeCacheEntries(state.CacheVars, []cacheEntry{entry})
+		if err := saveBuildState(buildDir, state); err != nil {
+			fmt.Printf("Warning: Could not save build state: %s\n", err)
+		}
+		fmt.Printf("Set %s in %s, the next 'qs build' reconfigures with it\n", entry, buildDir)
+
+	case "unset":
+		if len(args) < 2 {
+			fmt.Println("Error: usage is 'qs config unset <name>'")
+			return
+		}
+		name := args[1]
+		removed, err := removeCacheEntry(cachePath, name)
+		if err != nil {
+			fmt.Printf("Error updating %s: %s\n", cachePath, err)
+			return
+		}
+
+		state := loadBuildState(buildDir)
+		var kept []cacheEntry
+		for _, entry := range state.CacheVars {
+			if entry.Name == name {
+				removed = true
+				continue
+			}
+			kept = append(kept, entry)
+		}
+		state.CacheVars = kept
+		if err := saveBuildState(buildDir, state); err != nil {
+			fmt.Printf("Warning: Could not save build state: %s\n", err)
+		}
+
+		if removed {
+			fmt.Printf("Removed %s from %s, the next 'qs build' reconfigures without it\n", name, buildDir)
+		} else {
+			fmt.Printf("'%s' is not set in %s\n", name, buildDir)
+		}
+
+	default:
+		fmt.Printf("Error: unknown config command '%s' (expected get, set, unset or list)\n", args[0])
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCacheDefinition(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		definition string
+		want       cacheEntry
+	}{
+		{"untyped", "FOO=bar", cacheEntry{Name: "FOO", Value: "bar"}},
+		{"untyped empty value", "FOO=", cacheEntry{Name: "FOO", Value: ""}},
+		{"value with equals and colon", "FLAGS=-DA=1 -I:x", cacheEntry{Name: "FLAGS", Value: "-DA=1 -I:x"}},
+		{"untyped boolean is kept", "USE_X=true", cacheEntry{Name: "USE_X", Value: "true"}},
+		{"string", "NAME:STRING=hello world", cacheEntry{Name: "NAME", Type: "STRING", Value: "hello world"}},
+		{"lower case type", "NAME:string=x", cacheEntry{Name: "NAME", Type: "STRING", Value: "x"}},
+		{"bool on", "USE_X:BOOL=on", cacheEntry{Name: "USE_X", Type: "BOOL", Value: "ON"}},
+		{"bool true", "USE_X:BOOL=TRUE", cacheEntry{Name: "USE_X", Type: "BOOL", Value: "ON"}},
+		{"bool 1", "USE_X:BOOL=1", cacheEntry{Name: "USE_X", Type: "BOOL", Value: "ON"}},
+		{"bool no", "USE_X:BOOL=no", cacheEntry{Name: "USE_X", Type: "BOOL", Value: "OFF"}},
+		{"bool empty", "USE_X:BOOL=", cacheEntry{Name: "USE_X", Type: "BOOL", Value: "OFF"}},
+		{"relative path", "DATA:PATH=data", cacheEntry{Name: "DATA", Type: "PATH", Value: filepath.Join(cwd, "data")}},
+		{"relative file path", "TOOL:FILEPATH=tools/gen.py", cacheEntry{Name: "TOOL", Type: "FILEPATH", Value: filepath.Join(cwd, "tools", "gen.py")}},
+		{"absolute path", "DATA:PATH=/opt/data", cacheEntry{Name: "DATA", Type: "PATH", Value: "/opt/data"}},
+		{"empty path", "DATA:PATH=", cacheEntry{Name: "DATA", Type: "PATH", Value: ""}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseCacheDefinition(test.definition)
+			if err != nil {
+				t.Fatalf("parseCacheDefinition: %s", err)
+			}
+			if got != test.want {
+				t.Errorf("parseCacheDefinition:\n got %#v\nwant %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseCacheDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		definition string
+		want       string
+	}{
+		{"no equals", "FOO", "invalid cache definition 'FOO'"},
+		{"no name", "=bar", "invalid cache definition '=bar'"},
+		{"type without name", ":BOOL=ON", "invalid cache definition ':BOOL=ON': missing name"},
+		{"unknown type", "FOO:INT=1", "unknown cache type 'INT'"},
+		{"invalid bool", "USE_X:BOOL=maybe", "invalid BOOL value 'maybe' for USE_X"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseCacheDefinition(test.definition)
+			if err == nil {
+				t.Fatalf("parseCacheDefinition succeeded, want error %q", test.want)
+			}
+			if !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("parseCacheDefinition error %q, want %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestReadCache(t *testing.T) {
+	cache := "# This is the CMakeCache file.\n" +
+		"# For build in directory: /tmp/p/build/debug\n" +
+		"\n" +
+		"########################\n" +
+		"# EXTERNAL cache entries\n" +
+		"########################\n" +
+		"\n" +
+		"//Choose the type of build.\n" +
+		"CMAKE_BUILD_TYPE:STRING=Debug\r\n" +
+		"\n" +
+		"//Enable tests\n" +
+		"BUILD_TESTING:BOOL=TRUE\n" +
+		"\n" +
+		"//Path to a library.\n" +
+		"ZLIB_LIBRARY:FILEPATH=ZLIB_LIBRARY-NOTFOUND\n" +
+		"\n" +
+		"//Data directory\n" +
+		"DATA_DIR:PATH=data\n" +
+		"\n" +
+		"//Set by qs config\n" +
+		"FLAGS=-DA=1\n" +
+		"\n" +
+		"//ADVANCED property for variable: CMAKE_AR\n" +
+		"CMAKE_AR-ADVANCED:INTERNAL=1\n" +
+		"not an entry\n"
+	path := filepath.Join(t.TempDir(), "CMakeCache.txt")
+	if err := os.WriteFile(path, []byte(cache), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readCache(path)
+	if err != nil {
+		t.Fatalf("readCache: %s", err)
+	}
+	want := []cacheEntry{
+		{Name: "CMAKE_BUILD_TYPE", Type: "STRING", Value: "Debug"},
+		{Name: "BUILD_TESTING", Type: "BOOL", Value: "TRUE"},
+		{Name: "ZLIB_LIBRARY", Type: "FILEPATH", Value: "ZLIB_LIBRARY-NOTFOUND"},
+		{Name: "DATA_DIR", Type: "PATH", Value: "data"},
+		{Name: "FLAGS", Value: "-DA=1"},
+		{Name: "CMAKE_AR-ADVANCED", Type: "INTERNAL", Value: "1"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readCache:\n got %v\nwant %v", got, want)
+	}
+
+	if _, err := readCache(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("readCache of a missing file succeeded, want an error")
+	}
+}
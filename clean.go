@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+)
+
+// cleanProject runs the generator's clean target in the selected (or most
+// recently built) build directory
+func cleanProject(sel buildOptions) bool {
+	buildDir, err := selectBuildDir(sel)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return false
+	}
+
+	cachePath := filepath.Join(buildDir, "CMakeCache.txt")
+	args := []string{"--build", buildDir, "--target", "clean"}
+	if isMultiConfigGenerator(readCacheValue(cachePath, "CMAKE_GENERATOR")) {
+		if buildType := readCacheValue(cachePath, "CMAKE_BUILD_TYPE"); buildType != "" {
+			args = append(args, "--config", buildType)
+		}
+	}
+
+	fmt.Printf("Cleaning %s...\n", buildDir)
+	cmd := exec.Command("cmake", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Error cleaning build directory: %s\n", err)
+		return false
+	}
+	return true
+}
+
+// isQsBuildDir reports whether dir is a build directory, i.e. it holds a
+// CMakeCache.txt or qs state. distclean never deletes anything else.
+func isQsBuildDir(dir string) bool {
+	return fileExists(filepath.Join(dir, "CMakeCache.txt")) || fileExists(filepath.Join(dir, buildStateFile))
+}
+
+// findBuildDirs returns the build directories under the build root. A build
+// root that is itself a build directory (the layout of older qs versions) is
+// returned on its own.
+func findBuildDirs() []string {
+	if fileExists(filepath.Join(buildRoot, "CMakeCache.txt")) {
+		return []string{buildRoot}
+	}
+
+	entries, err := os.ReadDir(buildRoot)
+	if err != nil {
+		return nil
+	}
+	var dirs []string
+	for _, entry := range entries {
+		dir := filepath.Join(buildRoot, entry.Name())
+		if entry.IsDir() && isQsBuildDir(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// distcleanProject removes every build directory qs created, after asking
+// for confirmation unless assumeYes is set. Other files under the build root
+// are left alone.
+func distcleanProject(assumeYes bool) {
+	dirs := findBuildDirs()
+	if len(dirs) == 0 {
+		fmt.Println("No build directories found, nothing to remove.")
+		return
+	}
+
+	fmt.Println("The following build directories will be removed:")
+	for _, dir := range dirs {
+		fmt.Printf("  %s\n", dir)
+	}
+	if !assumeYes && !confirm("Remove them?") {
+		fmt.Println("Aborted.")
+		return
+	}
+
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			fmt.Printf("Error removing %s: %s\n", dir, err)
+			return
+		}
+		fmt.Printf("Removed %s\n", dir)
+	}
+
+	if isCompileCommandsLink() {
+		os.Remove(compileCommandsFile)
+	}
+
+	// Drop the active marker and the build root once nothing else is left in it
+	if isDir(buildRoot) {
+		os.Remove(filepath.Join(buildRoot, activeBuildFile))
+		if entries, err := os.ReadDir(buildRoot); err == nil && len(entries) == 0 {
+			os.Remove(buildRoot)
+		} else if err == nil {
+			fmt.Printf("Kept %s, it contains files qs did not create\n", buildRoot)
+		}
+	}
+}
@@ -25,10 +25,14 @@ func initProject() {
 	}
 
 	projectName := getProjectName()
+	standard := "14"
+	if settings.Init.CXXStandard != "" {
+		standard = settings.Init.CXXStandard
+	}
 	content := fmt.Sprintf(`cmake_minimum_required(VERSION 3.10)
 project(%s)
 
-set(CMAKE_CXX_STANDARD 14)
+set(CMAKE_CXX_STANDARD %s)
 set(CMAKE_CXX_STANDARD_REQUIRED ON)
 
 # Compiler options
@@ -44,7 +48,7 @@ include_directories(${CMAKE_CURRENT_SOURCE_DIR}/include)
 
 # Enable testing
 enable_testing()
-`, projectName)
+`, projectName, standard)
 
 	err = os.WriteFile("CMakeLists.txt", []byte(content), 0644)
 	if err != nil {
@@ -57,14 +61,56 @@ enable_testing()
 	addTarget(getProjectName(), []string{"src/main.cc"})
 }
 
+// Public header layouts for sub-projects
+const (
+	// layoutFlat places the sample header at include/<name>.h
+	layoutFlat = "flat"
+	// layoutNamespaced places the sample header at include/<name>/<name>.hpp
+	layoutNamespaced = "namespaced"
+)
+
+// defaultSubLayout returns the header layout used when 'qs init sub' is run
+// without --layout. It can be changed with the QS_SUB_LAYOUT environment
+// variable or init.layout in the settings.
+func defaultSubLayout() string {
+	if layout := strings.TrimSpace(os.Getenv("QS_SUB_LAYOUT")); layout != "" {
+		return layout
+	}
+	if settings.Init.Layout != "" {
+		return settings.Init.Layout
+	}
+	return layoutFlat
+}
+
+func isValidSubLayout(layout string) bool {
+	return layout == layoutFlat || layout == layoutNamespaced
+}
+
+// subHeaderPath returns the public header path of a sub-project relative to
+// its include directory, as it should appear in an #include line
+func subHeaderPath(subDirName string, layout string) string {
+	if layout == layoutNamespaced {
+		return subDirName + "/" + subDirName + ".hpp"
+	}
+	return subDirName + ".h"
+}
+
 // initSubProject creates a subdirectory with a CMakeLists.txt file for a sub-project
-func initSubProject(subDirName string) {
+func initSubProject(subDirName string, layout string) {
 	// Validate subdirectory name
 	if strings.TrimSpace(subDirName) == "" {
 		fmt.Println("Error: Please specify a valid subdirectory name.")
 		return
 	}
 
+	if layout == "" {
+		layout = defaultSubLayout()
+	}
+	if !isValidSubLayout(layout) {
+		fmt.Printf("Error: Unknown header layout '%s' (expected %s or %s)\n", layout, layoutFlat, layoutNamespaced)
+		return
+	}
+
 	// Check if parent CMakeLists.txt exists
 	if !fileExists("CMakeLists.txt") {
 		fmt.Println("Error: Main CMakeLists.txt not found in the current directory.")
@@ -83,7 +129,30 @@ func initSubProject(subDirName string) {
 	}
 
 	// Create the CMakeLists.txt for the subdirectory
-	subCMakeContent := fmt.Sprintf(`# %s sub-project
+	var subCMakeContent string
+	if layout == layoutNamespaced {
+		// Headers live under include/<name>/, so only the include root is exported
+		// and consumers have to spell out the library name in their #include lines
+		subCMakeContent = fmt.Sprintf(`# %s sub-project
+
+# Add source files
+file(GLOB SOURCES "*.cpp" "*.cc" "*.c" "src/*.cpp" "src/*.cc" "src/*.c")
+file(GLOB_RECURSE HEADERS "include/%s/*.h" "include/%s/*.hpp")
+
+# Add library
+add_library(%s ${SOURCES} ${HEADERS})
+
+# Link any dependencies if needed
+# target_link_libraries(%s PRIVATE dependency1 dependency2)
+
+# Set include directories for this library and targets that link it
+target_include_directories(%s PUBLIC
+    $<BUILD_INTERFACE:${CMAKE_CURRENT_SOURCE_DIR}/include>
+    $<INSTALL_INTERFACE:include>
+)
+`, subDirName, subDirName, subDirName, subDirName, subDirName, subDirName)
+	} else {
+		subCMakeContent = fmt.Sprintf(`# %s sub-project
 
 # Add include directories
 include_directories(${CMAKE_CURRENT_SOURCE_DIR}/include)
@@ -103,7 +172,11 @@ target_include_directories(%s PUBLIC
     $<BUILD_INTERFACE:${CMAKE_CURRENT_SOURCE_DIR}/include>
     $<INSTALL_INTERFACE:include>
 )
-
+`, subDirName, subDirName, subDirName, subDirName)
+	}
+	// Projects with managed install rules install the library from the root
+	if !hasInstallRules() {
+		subCMakeContent += fmt.Sprintf(`
 # Install rules
 install(TARGETS %s
     ARCHIVE DESTINATION lib
@@ -111,7 +184,8 @@ install(TARGETS %s
     RUNTIME DESTINATION bin
 )
 install(DIRECTORY include/ DESTINATION include)
-`, subDirName, subDirName, subDirName, subDirName, subDirName)
+`, subDirName)
+	}
 
 	subCMakePath := filepath.Join(subDirName, "CMakeLists.txt")
 	err := os.WriteFile(subCMakePath, []byte(subCMakeContent), 0644)
@@ -191,14 +265,17 @@ public:
 } // namespace %s
 `, subDirName, subDirName)
 
-	headerPath := filepath.Join(includeDir, subDirName+".h")
+	headerPath := filepath.Join(includeDir, filepath.FromSlash(subHeaderPath(subDirName, layout)))
+	if err := os.MkdirAll(filepath.Dir(headerPath), 0755); err != nil {
+		fmt.Printf("Error creating header directory: %v\n", err)
+	}
 	err = os.WriteFile(headerPath, []byte(headerContent), 0644)
 	if err != nil {
 		fmt.Printf("Error creating sample header: %v\n", err)
 	}
 
 	// Create a sample source file
-	sourceContent := fmt.Sprintf(`#include "%s.h"
+	sourceContent := fmt.Sprintf(`#include "%s"
 #include <iostream>
 
 namespace %s {
@@ -212,7 +289,7 @@ void Example::doSomething() {
 }
 
 } // namespace %s
-`, subDirName, subDirName, subDirName, subDirName)
+`, subHeaderPath(subDirName, layout), subDirName, subDirName, subDirName)
 
 	sourcePath := filepath.Join(srcDir, subDirName+".cc")
 	err = os.WriteFile(sourcePath, []byte(sourceContent), 0644)
@@ -220,7 +297,10 @@ void Example::doSomething() {
 		fmt.Printf("Error creating sample source: %v\n", err)
 	}
 
-	fmt.Printf("Successfully initialized sub-project '%s'.\n", subDirName)
+	refreshInstallRules()
+
+	fmt.Printf("Successfully initialized sub-project '%s' (%s layout).\n", subDirName, layout)
+	fmt.Printf("Include its header with: #include \"%s\"\n", subHeaderPath(subDirName, layout))
 	fmt.Printf("To link this library to an executable, use: target_link_libraries(your_executable PRIVATE %s)\n", subDirName)
 }
 
@@ -392,16 +472,27 @@ func addTarget(targetName string, sourceFiles []string) {
 	}
 
 	fmt.Printf("Added executable target '%s' with %d source files\n", targetName, len(expandedSourceFiles))
+	refreshInstallRules()
 }
 
 // addStandardConfig adds standard CMake configuration
-func addStandardConfig(cxxStd int) {
+func addStandardConfig(opts standardOptions) {
 	// Check if CMakeLists.txt exists
 	if !fileExists("CMakeLists.txt") {
 		fmt.Println("Error: CMakeLists.txt not found. Run 'qs init' first.")
 		return
 	}
 
+	if opts.Verify {
+		verifyStandards(opts)
+		return
+	}
+
+	if opts.Target != "" {
+		setTargetStandard(opts)
+		return
+	}
+
 	cmakelists, err := os.ReadFile("CMakeLists.txt")
 	if err != nil {
 		fmt.Printf("Error reading CMakeLists.txt: %v\n", err)
@@ -415,46 +506,52 @@ func addStandardConfig(cxxStd int) {
 		strings.Contains(cmakeContent, "CMAKE_ARCHIVE_OUTPUT_DIRECTORY")
 
 	// If user provided a C++ standard, update it in the file
-	if cxxStd > 0 {
-		// Use regex to replace existing CMAKE_CXX_STANDARD line
-		re := regexp.MustCompile(`set\(CMAKE_CXX_STANDARD \d+\)`)
-		if re.MatchString(cmakeContent) {
-			// Replace existing CMAKE_CXX_STANDARD value
-			cmakeContent = re.ReplaceAllString(cmakeContent, fmt.Sprintf("set(CMAKE_CXX_STANDARD %d)", cxxStd))
-			fmt.Printf("Updated C++ standard to C++%d\n", cxxStd)
+	if opts.CXX != "" {
+		var updated bool
+		cmakeContent, updated = setCMakeVariable(cmakeContent, "CMAKE_CXX_STANDARD", opts.CXX)
+		if updated {
+			fmt.Printf("Updated C++ standard to C++%s\n", opts.CXX)
 		} else {
-			// Add CMAKE_CXX_STANDARD to config
-			stdConfig := fmt.Sprintf("\n# C++ Standard\nset(CMAKE_CXX_STANDARD %d)\nset(CMAKE_CXX_STANDARD_REQUIRED ON)\n", cxxStd)
-			cmakeContent += stdConfig
-			fmt.Printf("Set C++ standard to C++%d\n", cxxStd)
+			fmt.Printf("Set C++ standard to C++%s\n", opts.CXX)
 		}
-	}
-
-	// Add standard configurations if not already present
-	if !stdConfigAdded {
-		// First, try to find target names for install command
-		re := regexp.MustCompile(`add_executable\(([^):\n\s]+)`)
-		matches := re.FindAllStringSubmatch(cmakeContent, -1)
-
-		targetNames := []string{}
-		for _, match := range matches {
-			if len(match) > 1 {
-				targetNames = append(targetNames, match[1])
-			}
+		if !hasCMakeVariable(cmakeContent, "CMAKE_CXX_STANDARD_REQUIRED") {
+			cmakeContent, _ = setCMakeVariable(cmakeContent, "CMAKE_CXX_STANDARD_REQUIRED", "ON")
 		}
+	}
 
-		installCmd := "# Add install target\n"
-		if len(targetNames) > 0 {
-			installCmd += fmt.Sprintf("install(TARGETS %s DESTINATION bin)\n", strings.Join(targetNames, " "))
+	// Same for the C standard
+	if opts.C != "" {
+		var updated bool
+		cmakeContent, updated = setCMakeVariable(cmakeContent, "CMAKE_C_STANDARD", opts.C)
+		if updated {
+			fmt.Printf("Updated C standard to C%s\n", opts.C)
 		} else {
-			installCmd += "# No targets found to install\n"
+			fmt.Printf("Set C standard to C%s\n", opts.C)
 		}
+		if !hasCMakeVariable(cmakeContent, "CMAKE_C_STANDARD_REQUIRED") {
+			cmakeContent, _ = setCMakeVariable(cmakeContent, "CMAKE_C_STANDARD_REQUIRED", "ON")
+		}
+	}
 
-		stdConfig := fmt.Sprintf(`
+	cmakeContent = raiseCMakeMinimum(cmakeContent, opts)
 
-%s`, installCmd)
+	// Compiler extensions (e.g. gnu++20 instead of c++20)
+	if opts.Extensions != "" {
+		cmakeContent, _ = setCMakeVariable(cmakeContent, "CMAKE_CXX_EXTENSIONS", opts.Extensions)
+		if hasCMakeVariable(cmakeContent, "CMAKE_C_STANDARD") {
+			cmakeContent, _ = setCMakeVariable(cmakeContent, "CMAKE_C_EXTENSIONS", opts.Extensions)
+		}
+		fmt.Printf("Set compiler extensions to %s\n", opts.Extensions)
+	}
 
-		cmakeContent += stdConfig
+	// Add standard configurations if not already present
+	if !stdConfigAdded {
+		// Install rules are generated from the project model and kept up to date
+		// when targets are added or removed
+		model, err := loadProject()
+		if err == nil {
+			cmakeContent = setInstallSection(cmakeContent, renderInstallRules(planInstallRules(model)))
+		}
 		fmt.Println("Added standard CMake configuration")
 	} else {
 		// Standard config already exists
@@ -499,6 +596,82 @@ func isSourceFile(filename string) bool {
 	return ext == ".cpp" || ext == ".c" || ext == ".cc" || ext == ".cxx" || ext == ".h" || ext == ".hpp" || ext == ".hxx"
 }
 
+// managedSectionRegex matches a section of a CMake file that qs maintains,
+// including the blank lines in front of it
+func managedSectionRegex(name string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(name)
+	return regexp.MustCompile(fmt.Sprintf(`(?s)\n*# qs:begin %s\n.*?# qs:end %s[ \t]*\n?`, quoted, quoted))
+}
+
+func hasManagedSection(content string, name string) bool {
+	return managedSectionRegex(name).MatchString(content)
+}
+
+// setManagedSection replaces the managed section with the given body, or
+// appends it to the end of the file if it does not exist yet
+func setManagedSection(content string, name string, body string) string {
+	section := fmt.Sprintf("# qs:begin %s\n# This section is maintained by qs, manual changes will be overwritten\n%s# qs:end %s\n", name, body, name)
+	re := managedSectionRegex(name)
+	if location := re.FindStringIndex(content); location != nil {
+		prefix := "\n\n"
+		if location[0] == 0 {
+			prefix = ""
+		}
+		return content[:location[0]] + prefix + section + content[location[1]:]
+	}
+	return strings.TrimRight(content, "\n") + "\n\n" + section
+}
+
+// managedSectionBody returns the content of a managed section without its
+// begin/end markers and header comment
+func managedSectionBody(content string, name string) string {
+	quoted := regexp.QuoteMeta(name)
+	re := regexp.MustCompile(fmt.Sprintf(`(?s)# qs:begin %s\n#[^\n]*\n(.*?)# qs:end %s`, quoted, quoted))
+	match := re.FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// withoutManagedSection returns content with the managed section removed
+func withoutManagedSection(content string, name string) string {
+	return managedSectionRegex(name).ReplaceAllString(content, "\n")
+}
+
+// walkProjectFiles calls fn for every regular file in the project tree,
+// skipping hidden directories and build directories
+func walkProjectFiles(root string, fn func(path string)) {
+	filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if path != root && isIgnoredDir(path) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		fn(path)
+		return nil
+	})
+}
+
+// isIgnoredDir reports whether a directory should be skipped when scanning
+// the project sources
+func isIgnoredDir(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || name == "build" || filepath.Clean(path) == buildRoot {
+		return true
+	}
+	// Any CMake binary directory, wherever it was created
+	return fileExists(filepath.Join(path, "CMakeCache.txt"))
+}
+
+func isCMakeFile(filename string) bool {
+	return filepath.Base(filename) == "CMakeLists.txt" || filepath.Ext(filename) == ".cmake"
+}
+
 func containsGlobChar(pattern string) bool {
 	return strings.ContainsAny(pattern, "*?[")
 }
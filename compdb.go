@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// compileCommandsFile is the compilation database CMake writes into the
+// build directory and qs links into the project root for clangd and linters
+const compileCommandsFile = "compile_commands.json"
+
+// linkCompileCommands points compile_commands.json in the project root at the
+// compilation database of buildDir. A regular file of that name was not made
+// by qs and is left alone.
+func linkCompileCommands(buildDir string) bool {
+	target := filepath.Join(buildDir, compileCommandsFile)
+	if !fileExists(target) {
+		return false
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return false
+	}
+	rel, err := filepath.Rel(cwd, target)
+	if err != nil {
+		rel = target
+	}
+
+	if info, err := os.Lstat(compileCommandsFile); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 {
+			fmt.Printf("Warning: %s in the project root is not a link made by qs, leaving it alone\n", compileCommandsFile)
+			return false
+		}
+		if current, err := os.Readlink(compileCommandsFile); err == nil && current == rel {
+			return true
+		}
+		if err := os.Remove(compileCommandsFile); err != nil {
+			fmt.Printf("Warning: Could not update %s: %s\n", compileCommandsFile, err)
+			return false
+		}
+	}
+
+	if err := os.Symlink(rel, compileCommandsFile); err != nil {
+		fmt.Printf("Warning: Could not link %s to %s: %s\n", compileCommandsFile, rel, err)
+		return false
+	}
+	return true
+}
+
+// isCompileCommandsLink reports whether compile_commands.json in the project
+// root is a link into the build root, as made by linkCompileCommands
+func isCompileCommandsLink() bool {
+	info, err := os.Lstat(compileCommandsFile)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	target, err := os.Readlink(compileCommandsFile)
+	return err == nil && strings.HasPrefix(filepath.ToSlash(target), buildRoot+"/")
+}
+
+// compdbCommand implements 'qs compdb': it reconfigures the selected (or most
+// recently built) build directory so CMake rewrites its compilation database,
+// and links it into the project root
+func compdbCommand(args []string) {
+	opts, err := parseBuildArgs(args)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	if len(opts.Targets) > 0 {
+		fmt.Printf("Error: unknown argument '%s'\n", opts.Targets[0])
+		return
+	}
+	opts.Reconfigure = true
+
+	buildDir, generator, ok := configureProject(opts)
+	if !ok {
+		return
+	}
+
+	target := filepath.Join(buildDir, compileCommandsFile)
+	data, err := os.ReadFile(target)
+	if err != nil {
+		fmt.Printf("Error: CMake did not write %s, the %s generator does not support it\n", compileCommandsFile, generator)
+		fmt.Println("Use Ninja or Makefiles, e.g. in a new build directory with 'qs build -G Ninja'.")
+		return
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		fmt.Printf("Warning: %s is not a valid compilation database: %s\n", relativePath(target), err)
+	}
+
+	if !linkCompileCommands(buildDir) {
+		fmt.Printf("Compilation database written to %s (%s)\n", relativePath(target), plural(len(entries), "file"))
+		return
+	}
+	fmt.Printf("%s -> %s (%s)\n", compileCommandsFile, relativePath(target), plural(len(entries), "file"))
+}
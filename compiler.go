@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// compilerInfo describes a C or C++ compiler found on the system
+type compilerInfo struct {
+	Command []string // compiler executable followed by any extra arguments
+	ID      string   // "GNU", "Clang", "AppleClang" or empty if unknown
+	Version string
+}
+
+// Path returns the compiler executable
+func (c compilerInfo) Path() string {
+	if len(c.Command) == 0 {
+		return ""
+	}
+	return c.Command[0]
+}
+
+// String formats the compiler for messages, e.g. "/usr/bin/g++ (GNU 12.2.0)"
+func (c compilerInfo) String() string {
+	if c.ID == "" {
+		return strings.Join(c.Command, " ")
+	}
+	return fmt.Sprintf("%s (%s %s)", strings.Join(c.Command, " "), c.ID, c.Version)
+}
+
+// findCXXCompiler locates the C++ compiler CMake would use: $CXX, then the
+// compiler recorded in the build cache, then the usual names on PATH
+func findCXXCompiler() (compilerInfo, bool) {
+	return findCompiler("CXX", "CMAKE_CXX_COMPILER", []string{"c++", "g++", "clang++"})
+}
+
+// findCCompiler locates the C compiler CMake would use: $CC, then the
+// compiler recorded in the build cache, then the usual names on PATH
+func findCCompiler() (compilerInfo, bool) {
+	return findCompiler("CC", "CMAKE_C_COMPILER", []string{"cc", "gcc", "clang"})
+}
+
+func findCompiler(envVar string, cacheVar string, candidates []string) (compilerInfo, bool) {
+	if value := strings.Fields(os.Getenv(envVar)); len(value) > 0 {
+		if path, err := exec.LookPath(value[0]); err == nil {
+			value[0] = path
+			return identifyCompiler(value), true
+		}
+	}
+
+	if value := readCacheValue(filepath.Join(activeBuildDir(), "CMakeCache.txt"), cacheVar); value != "" {
+		if _, err := os.Stat(value); err == nil {
+			return identifyCompiler([]string{value}), true
+		}
+	}
+
+	for _, name := range candidates {
+		if path, err := exec.LookPath(name); err == nil {
+			return identifyCompiler([]string{path}), true
+		}
+	}
+
+	return compilerInfo{}, false
+}
+
+// identifyCompiler runs the compiler with --version to find out what it is
+func identifyCompiler(command []string) compilerInfo {
+	info := compilerInfo{Command: command}
+
+	args := append(append([]string{}, command[1:]...), "--version")
+	output, err := exec.Command(command[0], args...).Output()
+	if err != nil {
+		return info
+	}
+	text := string(output)
+
+	versionRe := regexp.MustCompile(`(\d+\.\d+(\.\d+)?)`)
+	firstLine := strings.SplitN(strings.TrimSpace(text), "\n", 2)[0]
+	words := strings.Fields(firstLine)
+	if len(words) == 0 {
+		return info
+	}
+	switch {
+	case strings.Contains(text, "Apple clang"):
+		info.ID = "AppleClang"
+	case strings.Contains(text, "clang"):
+		info.ID = "Clang"
+	case strings.Contains(text, "Free Software Foundation") || strings.Contains(firstLine, "gcc") || strings.Contains(firstLine, "g++"):
+		info.ID = "GNU"
+	}
+	if match := versionRe.FindStringSubmatch(strings.TrimPrefix(firstLine, words[0])); match != nil {
+		info.Version = match[1]
+	}
+	return info
+}
+
+// compiles reports whether source compiles with the given flags.
+// lang is the file extension to use, e.g. ".cpp" or ".c".
+func (c compilerInfo) compiles(source string, lang string, flags ...string) bool {
+	dir, err := os.MkdirTemp("", "qs-probe")
+	if err != nil {
+		return false
+	}
+	defer os.RemoveAll(dir)
+
+	sourcePath := filepath.Join(dir, "probe"+lang)
+	if err := os.WriteFile(sourcePath, []byte(source), 0644); err != nil {
+		return false
+	}
+
+	args := append([]string{}, c.Command[1:]...)
+	args = append(args, flags...)
+	args = append(args, "-fsyntax-only", sourcePath)
+	cmd := exec.Command(c.Command[0], args...)
+	cmd.Dir = dir
+	return cmd.Run() == nil
+}
+
+// readCacheValue returns the value of an entry in a CMakeCache.txt file,
+// or an empty string if the file or entry does not exist
+func readCacheValue(cachePath string, name string) string {
+	file, err := os.Open(cachePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, name+":") && !strings.HasPrefix(line, name+"=") {
+			continue
+		}
+		if index := strings.Index(line, "="); index >= 0 {
+			return line[index+1:]
+		}
+	}
+	return ""
+}
+
+// compilerChoice is a compiler selected with 'qs build --compiler', built in
+// its own build directory
+type compilerChoice struct {
+	Spec string // as given: "gcc", "clang" or a compiler name or path
+	Name string // build directory prefix, e.g. "gcc", "clang" or "gcc-13"
+	C    string // C compiler, empty if there is no matching one
+	CXX  string // C++ compiler
+}
+
+// compilerFamilies maps the family names --compiler accepts to their C and
+// C++ drivers
+var compilerFamilies = map[string][2]string{
+	"gcc":   {"gcc", "g++"},
+	"clang": {"clang", "clang++"},
+}
+
+// companionCompilers returns the C and C++ drivers of a compiler given by
+// either one, e.g. g++-13 gives gcc-13 and g++-13. Cross prefixes and the
+// directory are kept.
+func companionCompilers(spec string) (string, string) {
+	dir, base := filepath.Split(spec)
+	pairs := [][2]string{{"clang", "clang++"}, {"gcc", "g++"}, {"cc", "c++"}}
+	// C++ names first, "clang++" also contains "clang"
+	for _, pair := range pairs {
+		if strings.Contains(base, pair[1]) {
+			return dir + strings.Replace(base, pair[1], pair[0], 1), spec
+		}
+	}
+	for _, pair := range pairs {
+		if strings.Contains(base, pair[0]) {
+			return spec, dir + strings.Replace(base, pair[0], pair[1], 1)
+		}
+	}
+	return "", spec
+}
+
+// resolveCompiler finds the compilers for a --compiler value: a family
+// ("gcc" or "clang") or a C or C++ compiler name or path
+func resolveCompiler(spec string) (compilerChoice, error) {
+	choice := compilerChoice{Spec: spec}
+	var c, cxx string
+	if family, ok := compilerFamilies[strings.ToLower(spec)]; ok {
+		choice.Name = strings.ToLower(spec)
+		c, cxx = family[0], family[1]
+	} else {
+		c, cxx = companionCompilers(spec)
+	}
+
+	path, err := exec.LookPath(cxx)
+	if err != nil {
+		return choice, fmt.Errorf("C++ compiler '%s' not found", cxx)
+	}
+	choice.CXX = path
+	if c != "" {
+		if path, err := exec.LookPath(c); err == nil {
+			choice.C = path
+		}
+	}
+
+	if choice.Name == "" {
+		// Name the directory after the compiler and its major version
+		info := identifyCompiler([]string{choice.CXX})
+		major := strings.SplitN(info.Version, ".", 2)[0]
+		switch {
+		case info.ID == "GNU" && major != "":
+			choice.Name = "gcc-" + major
+		case info.ID != "" && major != "":
+			choice.Name = strings.ToLower(info.ID) + "-" + major
+		default:
+			choice.Name = regexp.MustCompile(`[^a-z0-9._-]`).ReplaceAllString(strings.ToLower(filepath.Base(choice.CXX)), "x")
+		}
+	}
+	return choice, nil
+}
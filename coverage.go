@@ -0,0 +1,664 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Coverage instrumentations, chosen by compiler
+const (
+	coverageGcov = "gcov" // GCC: --coverage, read with gcov --json-format
+	coverageLLVM = "llvm" // Clang: source-based coverage, read with llvm-cov export
+)
+
+// coverageReportDir is where qs coverage puts its data and reports, inside
+// the build directory
+const coverageReportDir = "coverage"
+
+// coverageFlags returns the compile and link flags for an instrumentation
+func coverageFlags(instrumentation string) string {
+	if instrumentation == coverageLLVM {
+		return "-fprofile-instr-generate -fcoverage-mapping"
+	}
+	return "--coverage"
+}
+
+// fileCoverage holds the execution counts of one source file
+type fileCoverage struct {
+	Path     string          // relative to the project root, with forward slashes
+	Lines    map[int]int64   // execution count per executable line
+	Branches map[int][]int64 // execution count per branch outcome, by line
+}
+
+// addBranches adds the counts of the branch outcomes on a line. The same
+// line compiled into several translation units has its counts summed.
+func (f *fileCoverage) addBranches(line int, counts []int64) {
+	existing := f.Branches[line]
+	for i, count := range counts {
+		if i < len(existing) {
+			existing[i] += count
+		} else {
+			existing = append(existing, count)
+		}
+	}
+	f.Branches[line] = existing
+}
+
+// summary counts the executable and covered lines and branches of the file
+func (f *fileCoverage) summary() coverageSummary {
+	var s coverageSummary
+	for _, count := range f.Lines {
+		s.Lines++
+		if count > 0 {
+			s.LinesCovered++
+		}
+	}
+	for _, counts := range f.Branches {
+		for _, count := range counts {
+			s.Branches++
+			if count > 0 {
+				s.BranchesCovered++
+			}
+		}
+	}
+	return s
+}
+
+// coverageSummary counts executable and covered lines and branches
+type coverageSummary struct {
+	Lines, LinesCovered       int
+	Branches, BranchesCovered int
+}
+
+func (s *coverageSummary) add(other coverageSummary) {
+	s.Lines += other.Lines
+	s.LinesCovered += other.LinesCovered
+	s.Branches += other.Branches
+	s.BranchesCovered += other.BranchesCovered
+}
+
+// lineRate returns the covered fraction of lines, 0 to 1
+func (s coverageSummary) lineRate() float64 {
+	return rate(s.LinesCovered, s.Lines)
+}
+
+// branchRate returns the covered fraction of branches, 0 to 1
+func (s coverageSummary) branchRate() float64 {
+	return rate(s.BranchesCovered, s.Branches)
+}
+
+func rate(covered int, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total)
+}
+
+// percent formats a covered count as a percentage, or "-" if there is
+// nothing to cover
+func percent(covered int, total int) string {
+	if total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", 100*rate(covered, total))
+}
+
+// coverageData holds the coverage of the project's source files by path
+type coverageData map[string]*fileCoverage
+
+// file returns the coverage of a source file given by its absolute path,
+// or nil for files outside the project (system headers) and generated files
+// in the build directories
+func (d coverageData) file(path string) *fileCoverage {
+	rel, ok := projectRelativePath(path)
+	if !ok {
+		return nil
+	}
+	if d[rel] == nil {
+		d[rel] = &fileCoverage{Path: rel, Lines: map[int]int64{}, Branches: map[int][]int64{}}
+	}
+	return d[rel]
+}
+
+// sortedFiles returns the files sorted by path
+func (d coverageData) sortedFiles() []*fileCoverage {
+	var files []*fileCoverage
+	for _, file := range d {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+// projectRelativePath returns an absolute path relative to the project root
+// with forward slashes, and whether it is a source file of the project
+func projectRelativePath(path string) (string, bool) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(cwd, filepath.Clean(path))
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") || rel == buildRoot || strings.HasPrefix(rel, buildRoot+"/") {
+		return "", false
+	}
+	return rel, true
+}
+
+// coverageTool returns the command for a coverage tool matching the compiler,
+// e.g. gcov-12 for GCC 12 or llvm-cov-15 for Clang 15, falling back to the
+// tool next to the compiler and then the plain name
+func coverageTool(compiler compilerInfo, tool string) []string {
+	if compiler.ID == "AppleClang" {
+		return []string{"xcrun", tool}
+	}
+	if major := strings.Split(compiler.Version, ".")[0]; major != "" {
+		if path, err := exec.LookPath(tool + "-" + major); err == nil {
+			return []string{path}
+		}
+	}
+	if candidate := filepath.Join(filepath.Dir(compiler.Path()), tool); fileExists(candidate) {
+		return []string{candidate}
+	}
+	return []string{tool}
+}
+
+// gcovReport is the part of the gcov JSON format qs reads
+type gcovReport struct {
+	CurrentWorkingDirectory string `json:"current_working_directory"`
+	Files                   []struct {
+		File  string `json:"file"`
+		Lines []struct {
+			LineNumber int   `json:"line_number"`
+			Count      int64 `json:"count"`
+			Branches   []struct {
+				Count int64 `json:"count"`
+				Throw bool  `json:"throw"`
+			} `json:"branches"`
+		} `json:"lines"`
+	} `json:"files"`
+}
+
+// collectGcov reads the .gcda files written by the tests with gcov
+func collectGcov(compiler compilerInfo, buildDir string) (coverageData, error) {
+	var dataFiles []string
+	filepath.WalkDir(buildDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(path, ".gcda") {
+			if rel, err := filepath.Rel(buildDir, path); err == nil {
+				dataFiles = append(dataFiles, rel)
+			}
+		}
+		return nil
+	})
+
+	data := coverageData{}
+	gcov := coverageTool(compiler, "gcov")
+	const batchSize = 100
+	for start := 0; start < len(dataFiles); start += batchSize {
+		end := start + batchSize
+		if end > len(dataFiles) {
+			end = len(dataFiles)
+		}
+		args := append(append([]string{}, gcov[1:]...), "--branch-probabilities", "--json-format", "--stdout")
+		cmd := exec.Command(gcov[0], append(args, dataFiles[start:end]...)...)
+		cmd.Dir = buildDir
+		output, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("running %s: %s", strings.Join(gcov, " "), err)
+		}
+
+		// One JSON document per data file
+		decoder := json.NewDecoder(bytes.NewReader(output))
+		for {
+			var report gcovReport
+			if err := decoder.Decode(&report); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, fmt.Errorf("reading %s output: %s", strings.Join(gcov, " "), err)
+			}
+			for _, file := range report.Files {
+				path := file.File
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(report.CurrentWorkingDirectory, path)
+				}
+				coverage := data.file(path)
+				if coverage == nil {
+					continue
+				}
+				for _, line := range file.Lines {
+					coverage.Lines[line.LineNumber] += line.Count
+					var counts []int64
+					for _, branch := range line.Branches {
+						// Exception edges are not branches in the source
+						if !branch.Throw {
+							counts = append(counts, branch.Count)
+						}
+					}
+					if len(counts) > 0 {
+						coverage.addBranches(line.LineNumber, counts)
+					}
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// llvmExport is the part of the llvm-cov export JSON format qs reads
+type llvmExport struct {
+	Data []struct {
+		Files []struct {
+			Filename string          `json:"filename"`
+			Segments [][]interface{} `json:"segments"`
+			Branches [][]float64     `json:"branches"`
+		} `json:"files"`
+	} `json:"data"`
+}
+
+// llvmSegment is a coverage segment: the count that applies from a position
+// until the next segment
+type llvmSegment struct {
+	Line          int
+	Count         int64
+	HasCount      bool
+	IsRegionEntry bool
+	IsGapRegion   bool
+}
+
+// parseLLVMSegment decodes [line, col, count, hasCount, isRegionEntry, isGapRegion]
+func parseLLVMSegment(values []interface{}) llvmSegment {
+	var s llvmSegment
+	number := func(i int) float64 {
+		if i < len(values) {
+			if v, ok := values[i].(float64); ok {
+				return v
+			}
+		}
+		return 0
+	}
+	flag := func(i int) bool {
+		if i < len(values) {
+			if v, ok := values[i].(bool); ok {
+				return v
+			}
+		}
+		return false
+	}
+	s.Line = int(number(0))
+	s.Count = int64(number(2))
+	s.HasCount = flag(3)
+	s.IsRegionEntry = flag(4)
+	s.IsGapRegion = flag(5)
+	return s
+}
+
+// llvmLineCounts turns the segments of a file into line execution counts,
+// the way llvm-cov show does: a line is executable if a region starts on it
+// or a counted region continues into it, and its count is the highest of
+// those regions
+func llvmLineCounts(segments []llvmSegment) map[int]int64 {
+	lines := map[int]int64{}
+	if len(segments) == 0 {
+		return lines
+	}
+
+	var wrapped *llvmSegment
+	i := 0
+	for line := segments[0].Line; line <= segments[len(segments)-1].Line; line++ {
+		start := i
+		for i < len(segments) && segments[i].Line == line {
+			i++
+		}
+		lineSegments := segments[start:i]
+
+		regions := 0
+		for _, s := range lineSegments {
+			if s.HasCount && s.IsRegionEntry && !s.IsGapRegion {
+				regions++
+			}
+		}
+		skipped := len(lineSegments) > 0 && !lineSegments[0].HasCount && lineSegments[0].IsRegionEntry
+		if !skipped && ((wrapped != nil && wrapped.HasCount) || regions > 0) {
+			var count int64
+			if wrapped != nil {
+				count = wrapped.Count
+			}
+			for _, s := range lineSegments {
+				if s.HasCount && s.IsRegionEntry && !s.IsGapRegion && s.Count > count {
+					count = s.Count
+				}
+			}
+			lines[line] = count
+		}
+
+		if len(lineSegments) > 0 {
+			wrapped = &lineSegments[len(lineSegments)-1]
+		}
+	}
+	return lines
+}
+
+// collectLLVM merges the raw profiles written by the tests and reads the
+// coverage of all programs and libraries in the build directory with llvm-cov
+func collectLLVM(compiler compilerInfo, buildDir string, reportDir string) (coverageData, error) {
+	data := coverageData{}
+	profiles, _ := filepath.Glob(filepath.Join(reportDir, "profraw", "*.profraw"))
+	if len(profiles) == 0 {
+		return data, nil
+	}
+
+	profdata := filepath.Join(reportDir, "coverage.profdata")
+	tool := coverageTool(compiler, "llvm-profdata")
+	args := append(append([]string{}, tool[1:]...), "merge", "-sparse", "-o", profdata)
+	cmd := exec.Command(tool[0], append(args, profiles...)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %s", strings.Join(tool, " "), err)
+	}
+
+	binaries := findBinaries(buildDir)
+	if len(binaries) == 0 {
+		return data, nil
+	}
+	tool = coverageTool(compiler, "llvm-cov")
+	args = append(append([]string{}, tool[1:]...), "export", "-format=text", "-instr-profile="+profdata, binaries[0])
+	for _, binary := range binaries[1:] {
+		args = append(args, "-object", binary)
+	}
+	output, err := exec.Command(tool[0], args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("running %s: %s", strings.Join(tool, " "), err)
+	}
+
+	var export llvmExport
+	if err := json.Unmarshal(output, &export); err != nil {
+		return nil, fmt.Errorf("reading %s output: %s", strings.Join(tool, " "), err)
+	}
+	for _, unit := range export.Data {
+		for _, file := range unit.Files {
+			coverage := data.file(file.Filename)
+			if coverage == nil {
+				continue
+			}
+			var segments []llvmSegment
+			for _, values := range file.Segments {
+				segments = append(segments, parseLLVMSegment(values))
+			}
+			for line, count := range llvmLineCounts(segments) {
+				coverage.Lines[line] += count
+			}
+			// [lineStart, colStart, lineEnd, colEnd, trueCount, falseCount, ...]
+			for _, branch := range file.Branches {
+				if len(branch) >= 6 {
+					coverage.addBranches(int(branch[0]), []int64{int64(branch[4]), int64(branch[5])})
+				}
+			}
+		}
+	}
+	return data, nil
+}
+
+// findBinaries returns the programs and shared libraries in a build
+// directory, recognized by their ELF or Mach-O header
+func findBinaries(buildDir string) []string {
+	var binaries []string
+	filepath.WalkDir(buildDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if entry.Name() == "CMakeFiles" || entry.Name() == coverageReportDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		if info.Mode()&0111 == 0 && !strings.Contains(entry.Name(), ".so") && !strings.HasSuffix(entry.Name(), ".dylib") {
+			return nil
+		}
+		if isBinaryFile(path) {
+			binaries = append(binaries, path)
+		}
+		return nil
+	})
+	sort.Strings(binaries)
+	return binaries
+}
+
+// isBinaryFile reports whether a file starts with an ELF or Mach-O header
+func isBinaryFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(file, magic); err != nil {
+		return false
+	}
+	switch string(magic) {
+	case "\x7fELF", "\xcf\xfa\xed\xfe", "\xce\xfa\xed\xfe", "\xca\xfe\xba\xbe":
+		return true
+	}
+	return false
+}
+
+// cmakeTargetDirRegex finds the target of an object file, e.g.
+// CMakeFiles/app.dir/src/main.cpp.o
+var cmakeTargetDirRegex = regexp.MustCompile(`CMakeFiles/([^/\s]+)\.dir/`)
+
+// sourceTargets maps the project's source files to the target they are
+// compiled into, using the compilation database of a build directory.
+// Headers do not appear in it.
+func sourceTargets(buildDir string) map[string]string {
+	targets := map[string]string{}
+	data, err := os.ReadFile(filepath.Join(buildDir, compileCommandsFile))
+	if err != nil {
+		return targets
+	}
+	var entries []struct {
+		Directory string   `json:"directory"`
+		File      string   `json:"file"`
+		Command   string   `json:"command"`
+		Arguments []string `json:"arguments"`
+		Output    string   `json:"output"`
+	}
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return targets
+	}
+
+	for _, entry := range entries {
+		command := filepath.ToSlash(entry.Output + " " + entry.Command + " " + strings.Join(entry.Arguments, " "))
+		match := cmakeTargetDirRegex.FindStringSubmatch(command)
+		if match == nil {
+			continue
+		}
+		path := entry.File
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(entry.Directory, path)
+		}
+		if rel, ok := projectRelativePath(path); ok {
+			targets[rel] = match[1]
+		}
+	}
+	return targets
+}
+
+// resetCoverageCounters removes the counts of earlier runs, so the report
+// only covers the tests about to run
+func resetCoverageCounters(buildDir string, reportDir string) {
+	filepath.WalkDir(buildDir, func(path string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() && strings.HasSuffix(path, ".gcda") {
+			os.Remove(path)
+		}
+		return nil
+	})
+	os.RemoveAll(filepath.Join(reportDir, "profraw"))
+}
+
+// printCoverageSummary prints line and branch coverage per file, per target
+// and in total
+func printCoverageSummary(data coverageData, targets map[string]string) {
+	files := data.sortedFiles()
+
+	width := len("Total")
+	for _, file := range files {
+		if len(file.Path) > width {
+			width = len(file.Path)
+		}
+	}
+	for _, target := range targets {
+		if len(target) > width {
+			width = len(target)
+		}
+	}
+	row := func(name string, s coverageSummary) {
+		fmt.Printf("  %-*s  %7s %11s  %7s %11s\n", width, name,
+			percent(s.LinesCovered, s.Lines), fmt.Sprintf("%d/%d", s.LinesCovered, s.Lines),
+			percent(s.BranchesCovered, s.Branches), fmt.Sprintf("%d/%d", s.BranchesCovered, s.Branches))
+	}
+	header := func(title string) {
+		fmt.Printf("\n  %-*s  %19s  %19s\n", width, title, "Lines", "Branches")
+	}
+
+	var total coverageSummary
+	byTarget := map[string]*coverageSummary{}
+	header("File")
+	for _, file := range files {
+		s := file.summary()
+		row(file.Path, s)
+		total.add(s)
+		if target, ok := targets[file.Path]; ok {
+			if byTarget[target] == nil {
+				byTarget[target] = &coverageSummary{}
+			}
+			byTarget[target].add(s)
+		}
+	}
+
+	if len(byTarget) > 0 {
+		var names []string
+		for name := range byTarget {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		header("Target")
+		for _, name := range names {
+			row(name, *byTarget[name])
+		}
+	}
+
+	fmt.Println()
+	row("Total", total)
+}
+
+// coverageCommand implements 'qs coverage': it builds an instrumented
+// configuration, runs the tests and reports the coverage
+func coverageCommand(args []string) {
+	opts, err := parseBuildArgs(args)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	if len(opts.Targets) > 0 {
+		fmt.Printf("Error: unknown argument '%s', qs coverage builds and tests the whole project\n", opts.Targets[0])
+		return
+	}
+	if opts.Toolchain != "" {
+		fmt.Println("Error: qs coverage runs the tests on this machine and cannot use a cross toolchain")
+		return
+	}
+
+	compiler, found := findCXXCompiler()
+	if opts.Compiler.CXX != "" {
+		compiler, found = identifyCompiler([]string{opts.Compiler.CXX}), true
+	}
+	if !found {
+		fmt.Println("Error: no C++ compiler found")
+		return
+	}
+	switch compiler.ID {
+	case "GNU":
+		opts.Coverage = coverageGcov
+	case "Clang", "AppleClang":
+		opts.Coverage = coverageLLVM
+	default:
+		fmt.Printf("Error: coverage needs GCC or Clang, found %s\n", compiler)
+		return
+	}
+
+	if !buildProject(opts) {
+		return
+	}
+	buildDir, err := filepath.Abs(opts.Dir())
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return
+	}
+	reportDir := filepath.Join(buildDir, coverageReportDir)
+
+	// Run the tests on fresh counters
+	resetCoverageCounters(buildDir, reportDir)
+	env := sanitizerEnv(buildDir)
+	if env == nil {
+		env = os.Environ()
+	}
+	if opts.Coverage == coverageLLVM {
+		env = setEnv(env, "LLVM_PROFILE_FILE", filepath.Join(reportDir, "profraw", "%p-%m.profraw"))
+	}
+	fmt.Printf("Running tests in %s...\n", opts.Dir())
+	if err := runCTest(buildDir, nil, env); err != nil {
+		fmt.Printf("Warning: tests failed (%s), the coverage is from the tests that ran\n", err)
+	}
+
+	var data coverageData
+	if opts.Coverage == coverageLLVM {
+		data, err = collectLLVM(compiler, buildDir, reportDir)
+	} else {
+		data, err = collectGcov(compiler, buildDir)
+	}
+	if err != nil {
+		fmt.Printf("Error collecting coverage: %s\n", err)
+		return
+	}
+	if len(data) == 0 {
+		fmt.Println("No coverage was recorded. Add tests with add_test() and run 'qs coverage' again.")
+		return
+	}
+
+	fmt.Printf("\nCoverage (%s):\n", opts.Dir())
+	printCoverageSummary(data, sourceTargets(buildDir))
+
+	if err := os.MkdirAll(reportDir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %s\n", reportDir, err)
+		return
+	}
+	htmlIndex, err := writeCoverageHTML(data, filepath.Join(reportDir, "html"))
+	if err != nil {
+		fmt.Printf("Error writing HTML report: %s\n", err)
+	} else {
+		fmt.Printf("\nHTML report: %s\n", relativePath(htmlIndex))
+	}
+	xmlPath := filepath.Join(reportDir, "cobertura.xml")
+	if err := writeCobertura(data, xmlPath); err != nil {
+		fmt.Printf("Error writing Cobertura report: %s\n", err)
+	} else {
+		fmt.Printf("Cobertura XML: %s\n", relativePath(xmlPath))
+	}
+}
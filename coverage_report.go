@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"html/template"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// coverageStyle is shared by the pages of the HTML report
+const coverageStyle = `
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { padding: 2px 10px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+tr:nth-child(even) { background: #f4f4f4; }
+.low { color: #b00; } .medium { color: #a60; } .high { color: #070; }
+.source td { font-family: monospace; white-space: pre; text-align: left; padding: 0 8px; }
+.source td.number, .source td.count { text-align: right; color: #777; }
+.source .hit { background: #dfd; } .source .miss { background: #fdd; } .source .partial { background: #ffd; }
+`
+
+var coverageIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Coverage: {{.Project}}</title><style>{{.Style}}</style></head>
+<body>
+<h1>Coverage: {{.Project}}</h1>
+<p>Generated by qs on {{.Date}}</p>
+<table>
+<tr><th>File</th><th>Lines</th><th></th><th>Branches</th><th></th></tr>
+{{range .Rows}}<tr><td>{{if .Link}}<a href="{{.Link}}">{{.Name}}</a>{{else}}<b>{{.Name}}</b>{{end}}</td>
+<td class="{{.LineClass}}">{{.LinePercent}}</td><td>{{.LineCount}}</td>
+<td class="{{.BranchClass}}">{{.BranchPercent}}</td><td>{{.BranchCount}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+var coverageFileTemplate = template.Must(template.New("file").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Row.Name}}</title><style>{{.Style}}</style></head>
+<body>
+<p><a href="{{.Index}}">All files</a></p>
+<h1>{{.Row.Name}}</h1>
+<p>Lines: {{.Row.LinePercent}} ({{.Row.LineCount}}), branches: {{.Row.BranchPercent}} ({{.Row.BranchCount}})</p>
+<table class="source">
+{{range .Lines}}<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="count">{{.Count}}</td><td class="count">{{.Branches}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// coverageRow is a line of the summary table of the HTML report
+type coverageRow struct {
+	Name, Link                              string
+	LinePercent, LineCount, LineClass       string
+	BranchPercent, BranchCount, BranchClass string
+}
+
+func newCoverageRow(name string, link string, s coverageSummary) coverageRow {
+	return coverageRow{
+		Name:          name,
+		Link:          link,
+		LinePercent:   percent(s.LinesCovered, s.Lines),
+		LineCount:     fmt.Sprintf("%d/%d", s.LinesCovered, s.Lines),
+		LineClass:     coverageClass(s.LinesCovered, s.Lines),
+		BranchPercent: percent(s.BranchesCovered, s.Branches),
+		BranchCount:   fmt.Sprintf("%d/%d", s.BranchesCovered, s.Branches),
+		BranchClass:   coverageClass(s.BranchesCovered, s.Branches),
+	}
+}
+
+// coverageClass rates a coverage for coloring: below 50%, below 80% or above
+func coverageClass(covered int, total int) string {
+	switch r := rate(covered, total); {
+	case total == 0:
+		return ""
+	case r < 0.5:
+		return "low"
+	case r < 0.8:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// writeCoverageHTML writes an HTML report with a summary page and an
+// annotated source page per file, and returns the path of the summary page
+func writeCoverageHTML(data coverageData, dir string) (string, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+
+	var rows []coverageRow
+	var total coverageSummary
+	for _, file := range data.sortedFiles() {
+		s := file.summary()
+		total.add(s)
+		link := file.Path + ".html"
+		row := newCoverageRow(file.Path, link, s)
+		rows = append(rows, row)
+		if err := writeCoverageFilePage(file, row, filepath.Join(dir, filepath.FromSlash(link))); err != nil {
+			return "", err
+		}
+	}
+	rows = append(rows, newCoverageRow("Total", "", total))
+
+	index := filepath.Join(dir, "index.html")
+	out, err := os.Create(index)
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+	err = coverageIndexTemplate.Execute(out, map[string]interface{}{
+		"Project": getProjectName(),
+		"Date":    time.Now().Format("2006-01-02 15:04"),
+		"Style":   template.CSS(coverageStyle),
+		"Rows":    rows,
+	})
+	return index, err
+}
+
+// writeCoverageFilePage writes the source of a file with the execution count
+// of each line and the covered branches
+func writeCoverageFilePage(file *fileCoverage, row coverageRow, pagePath string) error {
+	// A file that went away since the build still gets a page, without source
+	source, _ := os.ReadFile(filepath.FromSlash(file.Path))
+
+	type sourceLine struct {
+		Number                int
+		Count, Branches, Text string
+		Class                 string
+	}
+	var lines []sourceLine
+	for i, text := range strings.Split(strings.TrimRight(string(source), "\n"), "\n") {
+		line := sourceLine{Number: i + 1, Text: strings.TrimRight(text, "\r")}
+		if count, ok := file.Lines[line.Number]; ok {
+			line.Count = fmt.Sprint(count)
+			line.Class = "miss"
+			if count > 0 {
+				line.Class = "hit"
+			}
+		}
+		if counts, ok := file.Branches[line.Number]; ok {
+			covered := 0
+			for _, count := range counts {
+				if count > 0 {
+					covered++
+				}
+			}
+			line.Branches = fmt.Sprintf("%d/%d", covered, len(counts))
+			if line.Class == "hit" && covered < len(counts) {
+				line.Class = "partial"
+			}
+		}
+		lines = append(lines, line)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(pagePath), 0755); err != nil {
+		return err
+	}
+	out, err := os.Create(pagePath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	return coverageFileTemplate.Execute(out, map[string]interface{}{
+		"Index": strings.Repeat("../", strings.Count(file.Path, "/")) + "index.html",
+		"Style": template.CSS(coverageStyle),
+		"Row":   row,
+		"Lines": lines,
+	})
+}
+
+// Cobertura XML report, as read by CI servers and code review tools
+type coberturaReport struct {
+	XMLName         xml.Name           `xml:"coverage"`
+	LineRate        string             `xml:"line-rate,attr"`
+	BranchRate      string             `xml:"branch-rate,attr"`
+	LinesCovered    int                `xml:"lines-covered,attr"`
+	LinesValid      int                `xml:"lines-valid,attr"`
+	BranchesCovered int                `xml:"branches-covered,attr"`
+	BranchesValid   int                `xml:"branches-valid,attr"`
+	Complexity      string             `xml:"complexity,attr"`
+	Version         string             `xml:"version,attr"`
+	Timestamp       int64              `xml:"timestamp,attr"`
+	Sources         []string           `xml:"sources>source"`
+	Packages        []coberturaPackage `xml:"packages>package"`
+}
+
+type coberturaPackage struct {
+	Name       string           `xml:"name,attr"`
+	LineRate   string           `xml:"line-rate,attr"`
+	BranchRate string           `xml:"branch-rate,attr"`
+	Complexity string           `xml:"complexity,attr"`
+	Classes    []coberturaClass `xml:"classes>class"`
+}
+
+type coberturaClass struct {
+	Name       string          `xml:"name,attr"`
+	Filename   string          `xml:"filename,attr"`
+	LineRate   string          `xml:"line-rate,attr"`
+	BranchRate string          `xml:"branch-rate,attr"`
+	Complexity string          `xml:"complexity,attr"`
+	Methods    struct{}        `xml:"methods"`
+	Lines      []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int    `xml:"number,attr"`
+	Hits              int64  `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr,omitempty"`
+}
+
+// coberturaRate formats a rate for the report
+func coberturaRate(r float64) string {
+	return fmt.Sprintf("%.4f", r)
+}
+
+// writeCobertura writes the coverage as Cobertura XML, with one package per
+// source directory
+func writeCobertura(data coverageData, xmlPath string) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var total coverageSummary
+	packages := map[string]*coberturaPackage{}
+	packageTotals := map[string]*coverageSummary{}
+	for _, file := range data.sortedFiles() {
+		s := file.summary()
+		total.add(s)
+
+		dir := path.Dir(file.Path)
+		if packages[dir] == nil {
+			packages[dir] = &coberturaPackage{Name: strings.ReplaceAll(dir, "/", "."), Complexity: "0"}
+			packageTotals[dir] = &coverageSummary{}
+		}
+		packageTotals[dir].add(s)
+
+		class := coberturaClass{
+			Name:       strings.ReplaceAll(file.Path, "/", "."),
+			Filename:   file.Path,
+			LineRate:   coberturaRate(s.lineRate()),
+			BranchRate: coberturaRate(s.branchRate()),
+			Complexity: "0",
+		}
+		var numbers []int
+		for number := range file.Lines {
+			numbers = append(numbers, number)
+		}
+		sort.Ints(numbers)
+		for _, number := range numbers {
+			line := coberturaLine{Number: number, Hits: file.Lines[number]}
+			if counts, ok := file.Branches[number]; ok && len(counts) > 0 {
+				covered := 0
+				for _, count := range counts {
+					if count > 0 {
+						covered++
+					}
+				}
+				line.Branch = true
+				line.ConditionCoverage = fmt.Sprintf("%.0f%% (%d/%d)", 100*rate(covered, len(counts)), covered, len(counts))
+			}
+			class.Lines = append(class.Lines, line)
+		}
+		packages[dir].Classes = append(packages[dir].Classes, class)
+	}
+
+	report := coberturaReport{
+		LineRate:        coberturaRate(total.lineRate()),
+		BranchRate:      coberturaRate(total.branchRate()),
+		LinesCovered:    total.LinesCovered,
+		LinesValid:      total.Lines,
+		BranchesCovered: total.BranchesCovered,
+		BranchesValid:   total.Branches,
+		Complexity:      "0",
+		Version:         "qs " + version,
+		Timestamp:       time.Now().Unix(),
+		Sources:         []string{cwd},
+	}
+	var dirs []string
+	for dir := range packages {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		pkg := packages[dir]
+		pkg.LineRate = coberturaRate(packageTotals[dir].lineRate())
+		pkg.BranchRate = coberturaRate(packageTotals[dir].branchRate())
+		report.Packages = append(report.Packages, *pkg)
+	}
+
+	out, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	content := xml.Header + `<!DOCTYPE coverage SYSTEM "http://cobertura.sourceforge.net/xml/coverage-04.dtd">` + "\n" + string(out) + "\n"
+	return os.WriteFile(xmlPath, []byte(content), 0644)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diagnosticFormats lists the formats 'qs build --diagnostics' can write
+var diagnosticFormats = map[string]string{
+	"json":  "diagnostics.json",
+	"sarif": "diagnostics.sarif",
+}
+
+// diagnostic is one GCC, Clang or MSVC error, warning or note
+type diagnostic struct {
+	File     string       `json:"file"`
+	Line     int          `json:"line"`
+	Column   int          `json:"column,omitempty"`
+	Severity string       `json:"severity"` // "error", "warning" or "note"
+	Message  string       `json:"message"`
+	Flag     string       `json:"flag,omitempty"` // warning option, e.g. "-Wunused-variable" or "C4996"
+	Notes    []diagnostic `json:"notes,omitempty"`
+}
+
+// location formats the position as file:line:column
+func (d diagnostic) location() string {
+	if d.Column == 0 {
+		return fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+	return fmt.Sprintf("%s:%d:%d", d.File, d.Line, d.Column)
+}
+
+// diagnosticRegex matches "file:line[:col]: severity: message [-Wflag]"
+var diagnosticRegex = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*?)(?: \[([^\]]+)\])?$`)
+
+// msvcDiagnosticRegex matches "file(line[,col]): severity [code]: message",
+// as written by MSVC and clang-cl
+var msvcDiagnosticRegex = regexp.MustCompile(`^(.+?)\((\d+)(?:,(\d+))?\) ?: (fatal error|error|warning|note)(?: ([A-Z]+\d+))?: (.*)$`)
+
+// colorRegex matches the terminal color codes compilers may emit
+var colorRegex = regexp.MustCompile("\x1b\\[[0-9;]*[mK]")
+
+// diagnosticCollector picks compiler diagnostics out of build output
+// written to it, line by line
+type diagnosticCollector struct {
+	buildDir    string
+	partial     []byte
+	diagnostics []diagnostic
+	seen        map[string]bool
+	duplicate   bool // the last error or warning was a repeat, so its notes are dropped too
+}
+
+func newDiagnosticCollector(buildDir string) *diagnosticCollector {
+	return &diagnosticCollector{buildDir: buildDir, seen: map[string]bool{}}
+}
+
+// Write implements io.Writer
+func (c *diagnosticCollector) Write(data []byte) (int, error) {
+	c.partial = append(c.partial, data...)
+	for {
+		index := bytes.IndexByte(c.partial, '\n')
+		if index < 0 {
+			break
+		}
+		c.parseLine(string(c.partial[:index]))
+		c.partial = c.partial[index+1:]
+	}
+	return len(data), nil
+}
+
+// parseLine records the diagnostic on a line of build output, if any. Notes
+// are attached to the error or warning before them; a diagnostic reported
+// again by another translation unit (e.g. in a header) is only kept once.
+func (c *diagnosticCollector) parseLine(line string) {
+	line = strings.TrimRight(colorRegex.ReplaceAllString(line, ""), "\r")
+	d, ok := parseDiagnostic(line)
+	if !ok {
+		return
+	}
+	d.File = c.sourcePath(d.File)
+
+	if d.Severity == "note" {
+		if last := len(c.diagnostics) - 1; last >= 0 && !c.duplicate {
+			c.diagnostics[last].Notes = append(c.diagnostics[last].Notes, d)
+		}
+		return
+	}
+
+	key := d.location() + ":" + d.Severity + ":" + d.Message
+	c.duplicate = c.seen[key]
+	if c.duplicate {
+		return
+	}
+	c.seen[key] = true
+	c.diagnostics = append(c.diagnostics, d)
+}
+
+// parseDiagnostic parses a GCC, Clang or MSVC diagnostic line. The file is
+// returned as written by the compiler.
+func parseDiagnostic(line string) (diagnostic, bool) {
+	var d diagnostic
+	if match := diagnosticRegex.FindStringSubmatch(line); match != nil {
+		d = diagnostic{File: match[1], Severity: match[4], Message: match[5], Flag: warningFlag(match[6])}
+		d.Line, _ = strconv.Atoi(match[2])
+		d.Column, _ = strconv.Atoi(match[3])
+		if match[6] != "" && d.Flag == "" {
+			// Not a warning option, keep the bracketed text in the message
+			d.Message += " [" + match[6] + "]"
+		}
+	} else if match := msvcDiagnosticRegex.FindStringSubmatch(line); match != nil {
+		d = diagnostic{File: match[1], Severity: match[4], Message: match[6]}
+		d.Line, _ = strconv.Atoi(match[2])
+		d.Column, _ = strconv.Atoi(match[3])
+		if d.Severity == "warning" {
+			d.Flag = match[5]
+		} else if match[5] != "" {
+			d.Message = match[5] + ": " + d.Message
+		}
+	} else {
+		return d, false
+	}
+	if d.Severity == "fatal error" {
+		d.Severity = "error"
+	}
+	return d, true
+}
+
+// sourcePath makes a path from compiler output relative to the project root.
+// Compilers run in the build directory, so relative paths start there.
+func (c *diagnosticCollector) sourcePath(path string) string {
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(c.buildDir, path)
+	}
+	return filepath.ToSlash(relativePath(path))
+}
+
+// warningFlag extracts the warning option from the bracketed suffix GCC and
+// Clang add to warnings, e.g. "-Wunused-variable", "-Werror=unused-variable"
+// or "-Werror,-Wunused-variable"
+func warningFlag(text string) string {
+	flag := ""
+	for _, part := range strings.Split(text, ",") {
+		part = strings.TrimSpace(part)
+		if strings.HasPrefix(part, "-W") {
+			flag = part
+		}
+	}
+	if strings.HasPrefix(flag, "-Werror=") {
+		flag = "-W" + strings.TrimPrefix(flag, "-Werror=")
+	}
+	if flag == "-Werror" {
+		return ""
+	}
+	return flag
+}
+
+// counts returns the number of errors and warnings collected
+func (c *diagnosticCollector) counts() (int, int) {
+	errors, warnings := 0, 0
+	for _, d := range c.diagnostics {
+		if d.Severity == "error" {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	return errors, warnings
+}
+
+// printSummary prints the errors with their notes, then the number of
+// warnings per file and per warning option
+func (c *diagnosticCollector) printSummary() {
+	errors, warnings := c.counts()
+	if errors == 0 && warnings == 0 {
+		return
+	}
+	fmt.Printf("\nDiagnostics: %s, %s\n", plural(errors, "error"), plural(warnings, "warning"))
+
+	if errors > 0 {
+		fmt.Println("Errors:")
+		for _, d := range c.diagnostics {
+			if d.Severity != "error" {
+				continue
+			}
+			fmt.Printf("  %s: %s\n", d.location(), d.Message)
+			for _, note := range d.Notes {
+				fmt.Printf("    note: %s: %s\n", note.location(), note.Message)
+			}
+		}
+	}
+
+	if warnings > 0 {
+		byFile := map[string]int{}
+		byFlag := map[string]int{}
+		for _, d := range c.diagnostics {
+			if d.Severity != "warning" {
+				continue
+			}
+			byFile[d.File]++
+			flag := d.Flag
+			if flag == "" {
+				flag = "(no flag)"
+			}
+			byFlag[flag]++
+		}
+		fmt.Println("Warnings by file:")
+		printCounts(byFile)
+		fmt.Println("Warnings by flag:")
+		printCounts(byFlag)
+	}
+}
+
+// printCounts prints counts sorted from most to least, then by name
+func printCounts(counts map[string]int) {
+	var names []string
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	for _, name := range names {
+		fmt.Printf("  %5d  %s\n", counts[name], name)
+	}
+}
+
+// plural formats a count with a noun, e.g. "1 error" or "3 errors"
+func plural(count int, noun string) string {
+	if count == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", count, noun)
+}
+
+// writeDiagnostics writes the collected diagnostics to the build directory in
+// the given format and returns the path of the file
+func (c *diagnosticCollector) writeDiagnostics(format string) (string, error) {
+	diagnostics := c.diagnostics
+	if diagnostics == nil {
+		diagnostics = []diagnostic{}
+	}
+
+	var report interface{} = diagnostics
+	if format == "sarif" {
+		report = sarifReport(diagnostics)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(c.buildDir, diagnosticFormats[format])
+	return path, os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// sarifReport converts diagnostics to a SARIF 2.1.0 log, as read by code
+// review and code scanning tools
+func sarifReport(diagnostics []diagnostic) map[string]interface{} {
+	location := func(d diagnostic) map[string]interface{} {
+		region := map[string]interface{}{"startLine": d.Line}
+		if d.Column > 0 {
+			region["startColumn"] = d.Column
+		}
+		return map[string]interface{}{
+			"physicalLocation": map[string]interface{}{
+				"artifactLocation": map[string]interface{}{"uri": d.File},
+				"region":           region,
+			},
+		}
+	}
+
+	results := []map[string]interface{}{}
+	for _, d := range diagnostics {
+		result := map[string]interface{}{
+			"level":     d.Severity,
+			"message":   map[string]interface{}{"text": d.Message},
+			"locations": []map[string]interface{}{location(d)},
+		}
+		if d.Flag != "" {
+			result["ruleId"] = d.Flag
+		}
+		if len(d.Notes) > 0 {
+			var related []map[string]interface{}
+			for _, note := range d.Notes {
+				loc := location(note)
+				loc["message"] = map[string]interface{}{"text": note.Message}
+				related = append(related, loc)
+			}
+			result["relatedLocations"] = related
+		}
+		results = append(results, result)
+	}
+
+	return map[string]interface{}{
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"version": "2.1.0",
+		"runs": []map[string]interface{}{
+			{
+				"tool": map[string]interface{}{
+					"driver": map[string]interface{}{
+						"name":    "qs",
+						"version": version,
+					},
+				},
+				"results": results,
+			},
+		},
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseDiagnostic(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+		want diagnostic
+		ok   bool
+	}{
+		{
+			name: "gcc warning",
+			line: "../../src/main.cpp:12:9: warning: unused variable 'x' [-Wunused-variable]",
+			want: diagnostic{File: "../../src/main.cpp", Line: 12, Column: 9, Severity: "warning", Message: "unused variable 'x'", Flag: "-Wunused-variable"},
+			ok:   true,
+		},
+		{
+			name: "gcc warning as error",
+			line: "src/a.cpp:3:5: error: unused variable 'y' [-Werror=unused-variable]",
+			want: diagnostic{File: "src/a.cpp", Line: 3, Column: 5, Severity: "error", Message: "unused variable 'y'", Flag: "-Wunused-variable"},
+			ok:   true,
+		},
+		{
+			name: "gcc fatal error without column",
+			line: "src/a.cpp:1: fatal error: missing.h: No such file or directory",
+			want: diagnostic{File: "src/a.cpp", Line: 1, Severity: "error", Message: "missing.h: No such file or directory"},
+			ok:   true,
+		},
+		{
+			name: "gcc note",
+			line: "/usr/include/c++/12/bits/stl_vector.h:1121:7: note: candidate: 'void push_back(const value_type&)'",
+			want: diagnostic{File: "/usr/include/c++/12/bits/stl_vector.h", Line: 1121, Column: 7, Severity: "note", Message: "candidate: 'void push_back(const value_type&)'"},
+			ok:   true,
+		},
+		{
+			name: "clang warning with -Werror",
+			line: "src/b.cpp:7:10: error: implicit conversion loses integer precision [-Werror,-Wshorten-64-to-32]",
+			want: diagnostic{File: "src/b.cpp", Line: 7, Column: 10, Severity: "error", Message: "implicit conversion loses integer precision", Flag: "-Wshorten-64-to-32"},
+			ok:   true,
+		},
+		{
+			name: "clang bracket that is not a flag",
+			line: "src/b.cpp:8:1: error: no matching function [with T = int]",
+			want: diagnostic{File: "src/b.cpp", Line: 8, Column: 1, Severity: "error", Message: "no matching function [with T = int]"},
+			ok:   true,
+		},
+		{
+			name: "msvc warning",
+			line: `src\main.cpp(12,9): warning C4101: 'x': unreferenced local variable`,
+			want: diagnostic{File: `src\main.cpp`, Line: 12, Column: 9, Severity: "warning", Message: "'x': unreferenced local variable", Flag: "C4101"},
+			ok:   true,
+		},
+		{
+			name: "msvc error without column",
+			line: `C:\src\main.cpp(20): error C2065: 'y': undeclared identifier`,
+			want: diagnostic{File: `C:\src\main.cpp`, Line: 20, Severity: "error", Message: "C2065: 'y': undeclared identifier"},
+			ok:   true,
+		},
+		{
+			name: "msvc fatal error",
+			line: `src\a.cpp(1): fatal error C1083: Cannot open include file: 'missing.h': No such file or directory`,
+			want: diagnostic{File: `src\a.cpp`, Line: 1, Severity: "error", Message: "C1083: Cannot open include file: 'missing.h': No such file or directory"},
+			ok:   true,
+		},
+		{
+			name: "msvc note",
+			line: `src\a.h(4): note: see declaration of 'foo'`,
+			want: diagnostic{File: `src\a.h`, Line: 4, Severity: "note", Message: "see declaration of 'foo'"},
+			ok:   true,
+		},
+		{name: "ninja progress", line: "[2/5] Building CXX object CMakeFiles/app.dir/main.cpp.o"},
+		{name: "linker error", line: "collect2: error: ld returned 1 exit status"},
+		{name: "in file included from", line: "In file included from src/main.cpp:1:"},
+		{name: "empty", line: ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := parseDiagnostic(test.line)
+			if ok != test.ok {
+				t.Fatalf("parseDiagnostic matched %v, want %v", ok, test.ok)
+			}
+			if ok && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseDiagnostic:\n got %#v\nwant %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDiagnosticCollector(t *testing.T) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildDir := filepath.Join(cwd, "build", "debug")
+
+	tests := []struct {
+		name   string
+		output string
+		want   []diagnostic
+	}{
+		{
+			name: "notes attach to the diagnostic before them",
+			output: "[1/2] Building CXX object main.cpp.o\n" +
+				"../../src/main.cpp:5:3: error: no matching function for call to 'f'\n" +
+				"../../src/f.h:2:6: note: candidate function not viable\n" +
+				"../../src/f.h:3:6: note: candidate function not viable\n" +
+				"../../src/main.cpp:9:7: warning: unused variable 'x' [-Wunused-variable]\n",
+			want: []diagnostic{
+				{File: "src/main.cpp", Line: 5, Column: 3, Severity: "error", Message: "no matching function for call to 'f'", Notes: []diagnostic{
+					{File: "src/f.h", Line: 2, Column: 6, Severity: "note", Message: "candidate function not viable"},
+					{File: "src/f.h", Line: 3, Column: 6, Severity: "note", Message: "candidate function not viable"},
+				}},
+				{File: "src/main.cpp", Line: 9, Column: 7, Severity: "warning", Message: "unused variable 'x'", Flag: "-Wunused-variable"},
+			},
+		},
+		{
+			name: "a header warning from two translation units is kept once",
+			output: "../../src/util.h:4:9: warning: unused parameter 'n' [-Wunused-parameter]\n" +
+				"../../src/util.h:1:1: note: in expansion of macro\n" +
+				"../../src/util.h:4:9: warning: unused parameter 'n' [-Wunused-parameter]\n" +
+				"../../src/util.h:1:1: note: in expansion of macro\n",
+			want: []diagnostic{
+				{File: "src/util.h", Line: 4, Column: 9, Severity: "warning", Message: "unused parameter 'n'", Flag: "-Wunused-parameter", Notes: []diagnostic{
+					{File: "src/util.h", Line: 1, Column: 1, Severity: "note", Message: "in expansion of macro"},
+				}},
+			},
+		},
+		{
+			name:   "a note before any diagnostic is dropped",
+			output: "../../src/a.cpp:1:1: note: orphan\n",
+			want:   nil,
+		},
+		{
+			name:   "color codes and CRLF are stripped",
+			output: "\x1b[1m../../src/a.cpp:2:3: \x1b[0m\x1b[0;1;35mwarning: \x1b[0mshadowed [-Wshadow]\x1b[0m\r\n",
+			want: []diagnostic{
+				{File: "src/a.cpp", Line: 2, Column: 3, Severity: "warning", Message: "shadowed", Flag: "-Wshadow"},
+			},
+		},
+		{
+			name: "msvc warning and note",
+			output: cwd + "/src/a.cpp(7,5): warning C4996: 'strcpy': This function may be unsafe.\n" +
+				cwd + "/src/a.h(2): note: see declaration of 'strcpy'\n",
+			want: []diagnostic{
+				{File: "src/a.cpp", Line: 7, Column: 5, Severity: "warning", Message: "'strcpy': This function may be unsafe.", Flag: "C4996", Notes: []diagnostic{
+					{File: "src/a.h", Line: 2, Severity: "note", Message: "see declaration of 'strcpy'"},
+				}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newDiagnosticCollector(buildDir)
+			// Write in two pieces to check that lines split across writes are joined
+			half := len(test.output) / 2
+			c.Write([]byte(test.output[:half]))
+			c.Write([]byte(test.output[half:]))
+			if !reflect.DeepEqual(c.diagnostics, test.want) {
+				t.Errorf("diagnostics:\n got %#v\nwant %#v", c.diagnostics, test.want)
+			}
+		})
+	}
+}
+
+func TestSarifReport(t *testing.T) {
+	diagnostics := []diagnostic{
+		{File: "src/main.cpp", Line: 5, Column: 3, Severity: "error", Message: "no matching function", Notes: []diagnostic{
+			{File: "src/f.h", Line: 2, Severity: "note", Message: "candidate"},
+		}},
+		{File: "src/main.cpp", Line: 9, Column: 7, Severity: "warning", Message: "unused variable 'x'", Flag: "-Wunused-variable"},
+	}
+	want := `{
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "runs": [
+    {
+      "results": [
+        {
+          "level": "error",
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "src/main.cpp"
+                },
+                "region": {
+                  "startColumn": 3,
+                  "startLine": 5
+                }
+              }
+            }
+          ],
+          "message": {
+            "text": "no matching function"
+          },
+          "relatedLocations": [
+            {
+              "message": {
+                "text": "candidate"
+              },
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "src/f.h"
+                },
+                "region": {
+                  "startLine": 2
+                }
+              }
+            }
+          ]
+        },
+        {
+          "level": "warning",
+          "locations": [
+            {
+              "physicalLocation": {
+                "artifactLocation": {
+                  "uri": "src/main.cpp"
+                },
+                "region": {
+                  "startColumn": 7,
+                  "startLine": 9
+                }
+              }
+            }
+          ],
+          "message": {
+            "text": "unused variable 'x'"
+          },
+          "ruleId": "-Wunused-variable"
+        }
+      ],
+      "tool": {
+        "driver": {
+          "name": "qs",
+          "version": "` + version + `"
+        }
+      }
+    }
+  ],
+  "version": "2.1.0"
+}`
+
+	data, err := json.MarshalIndent(sarifReport(diagnostics), "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != want {
+		t.Errorf("sarifReport:\n got %s\nwant %s", data, want)
+	}
+}
+
+func TestWriteDiagnosticsEmpty(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"json", "[]\n"},
+		{"sarif", `"results": []`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.format, func(t *testing.T) {
+			c := newDiagnosticCollector(t.TempDir())
+			path, err := c.writeDiagnostics(test.format)
+			if err != nil {
+				t.Fatalf("writeDiagnostics: %s", err)
+			}
+			if filepath.Base(path) != diagnosticFormats[test.format] {
+				t.Errorf("writeDiagnostics wrote %s, want %s", path, diagnosticFormats[test.format])
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(string(data), test.want) {
+				t.Errorf("writeDiagnostics wrote %q, want it to contain %q", data, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// installHints tells how to install a tool on Linux, macOS and Windows
+var installHints = map[string][3]string{
+	"cmake":    {"sudo apt install cmake (or 'pip install cmake' for the latest release)", "brew install cmake", "winget install Kitware.CMake"},
+	"ninja":    {"sudo apt install ninja-build", "brew install ninja", "winget install Ninja-build.Ninja"},
+	"ccache":   {"sudo apt install ccache", "brew install ccache", "winget install Ccache.Ccache"},
+	"compiler": {"sudo apt install build-essential (or clang)", "xcode-select --install", "install the Visual Studio Build Tools"},
+}
+
+// installHint returns the command that installs a tool on this system
+func installHint(tool string) string {
+	hints := installHints[tool]
+	switch runtime.GOOS {
+	case "darwin":
+		return hints[1]
+	case "windows":
+		return hints[2]
+	}
+	return hints[0]
+}
+
+// doctorReport prints the results of 'qs doctor' and counts the problems
+type doctorReport struct {
+	Problems int
+	Warnings int
+}
+
+func (r *doctorReport) section(title string) {
+	fmt.Printf("\n%s\n", title)
+}
+
+func (r *doctorReport) ok(format string, args ...interface{}) {
+	fmt.Printf("  ok    %s\n", fmt.Sprintf(format, args...))
+}
+
+// note reports something that works, with a suggestion
+func (r *doctorReport) note(message string, fix string) {
+	fmt.Printf("  note  %s\n", message)
+	r.printFix(fix)
+}
+
+func (r *doctorReport) warn(message string, fix string) {
+	r.Warnings++
+	fmt.Printf("  warn  %s\n", message)
+	r.printFix(fix)
+}
+
+func (r *doctorReport) fail(message string, fix string) {
+	r.Problems++
+	fmt.Printf("  FAIL  %s\n", message)
+	r.printFix(fix)
+}
+
+func (r *doctorReport) printFix(fix string) {
+	if fix != "" {
+		fmt.Printf("        fix: %s\n", fix)
+	}
+}
+
+// describeTool formats a tool for the report, e.g. "ninja 1.11.1 (/usr/bin/ninja)"
+func describeTool(name string, path string) string {
+	if v := toolVersion(path); v != "" {
+		return fmt.Sprintf("%s %s (%s)", name, v, path)
+	}
+	return fmt.Sprintf("%s (%s)", name, path)
+}
+
+// toolVersion runs a tool with --version and returns the first version
+// number in its output
+func toolVersion(path string) string {
+	output, err := exec.Command(path, "--version").Output()
+	if err != nil {
+		return ""
+	}
+	if match := regexp.MustCompile(`\d+\.\d+(\.\d+)?`).FindString(string(output)); match != "" {
+		return match
+	}
+	return ""
+}
+
+// projectLanguages returns the languages the project() call enables. CMake
+// enables C and C++ when none are named.
+func projectLanguages(content string) []string {
+	match := regexp.MustCompile(`(?i)\bproject\s*\(([^)]*)\)`).FindStringSubmatch(content)
+	if match == nil {
+		return []string{"C", "CXX"}
+	}
+	known := []string{"C", "CXX", "CUDA", "OBJC", "OBJCXX", "Fortran", "HIP", "ISPC", "ASM", "NONE"}
+	var languages []string
+	fields := strings.Fields(match[1])
+	for i, field := range fields {
+		if i > 0 && containsWord(known, field) {
+			languages = append(languages, field)
+		}
+	}
+	if len(languages) == 0 {
+		return []string{"C", "CXX"}
+	}
+	return languages
+}
+
+// standardFlags returns the -std= spellings of a standard value
+func standardFlags(lang string, value string) []string {
+	table := cxxStandardFlags
+	if lang == "C" {
+		table = cStandardFlags
+	}
+	for _, std := range table {
+		if std.Value == value {
+			return std.Flags
+		}
+	}
+	return nil
+}
+
+// doctorCommand checks the tools a build needs and prints how to fix what
+// is missing or too old. It exits with status 1 when a build cannot work.
+func doctorCommand(args []string) {
+	if len(args) > 0 {
+		fmt.Printf("Error: unknown option '%s'\n", args[0])
+		os.Exit(1)
+	}
+
+	r := &doctorReport{}
+	content := ""
+	if data, err := os.ReadFile("CMakeLists.txt"); err == nil {
+		content = string(data)
+	}
+	fmt.Printf("qs %s on %s/%s\n", version, runtime.GOOS, runtime.GOARCH)
+
+	cmakeVersion := checkCMake(r, content)
+	checkBuildTools(r)
+	compilers := checkCompilers(r, content)
+	checkStandards(r, content, cmakeVersion, compilers)
+	checkOptionalTools(r)
+	checkBuildDirectory(r)
+
+	fmt.Println()
+	switch {
+	case r.Problems > 0:
+		found := plural(r.Problems, "problem")
+		if r.Warnings > 0 {
+			found += " and " + plural(r.Warnings, "warning")
+		}
+		fmt.Printf("%s found, builds will fail until the problems are fixed.\n", found)
+		os.Exit(1)
+	case r.Warnings > 0:
+		fmt.Printf("No problems found, %s.\n", plural(r.Warnings, "warning"))
+	default:
+		fmt.Println("No problems found.")
+	}
+}
+
+// checkCMake checks CMake is installed and new enough for the project, and
+// returns its version
+func checkCMake(r *doctorReport, content string) string {
+	r.section("CMake")
+	path, err := exec.LookPath("cmake")
+	if err != nil {
+		r.fail("cmake not found on PATH", installHint("cmake"))
+		return ""
+	}
+	cmakeVersion := toolVersion(path)
+	if cmakeVersion == "" {
+		r.fail(fmt.Sprintf("%s does not run", path), "reinstall CMake: "+installHint("cmake"))
+		return ""
+	}
+	r.ok("cmake %s (%s)", cmakeVersion, path)
+
+	minimum := cmakeMinimumVersion(content)
+	switch {
+	case content == "":
+		r.note("no CMakeLists.txt in the current directory, skipping the project checks", "run 'qs doctor' in the project root, or 'qs init' to create a project")
+	case minimum == "":
+		r.warn("CMakeLists.txt has no cmake_minimum_required", "add 'cmake_minimum_required(VERSION "+cmakeVersion+")' as its first line")
+	case !versionAtLeast(cmakeVersion, minimum):
+		r.fail(fmt.Sprintf("the project requires CMake %s, found %s", minimum, cmakeVersion),
+			"install CMake "+minimum+" or later: "+installHint("cmake"))
+	default:
+		r.ok("project requires CMake %s", minimum)
+	}
+	return cmakeVersion
+}
+
+// checkBuildTools checks for the native build tools the generators drive
+func checkBuildTools(r *doctorReport) {
+	r.section("Build tools")
+	ninja, ninjaErr := exec.LookPath("ninja")
+	if ninjaErr == nil {
+		r.ok("%s", describeTool("ninja", ninja))
+	}
+	if runtime.GOOS == "windows" {
+		if ninjaErr != nil {
+			r.note("ninja not found, CMake will use its default generator", installHint("ninja"))
+		}
+		return
+	}
+
+	makePath, makeErr := exec.LookPath("make")
+	if makeErr == nil {
+		r.ok("%s", describeTool("make", makePath))
+	}
+	switch {
+	case ninjaErr != nil && makeErr != nil:
+		r.fail("neither ninja nor make found, CMake cannot generate a build", installHint("ninja"))
+	case ninjaErr != nil:
+		r.note("ninja not found, building with make", "ninja builds faster and enables 'qs build --timings' without a wrapper: "+installHint("ninja"))
+	}
+}
+
+// checkCompilers checks the C and C++ compilers CMake will pick, and returns
+// the ones found by language
+func checkCompilers(r *doctorReport, content string) map[string]compilerInfo {
+	r.section("Compilers")
+	found := map[string]compilerInfo{}
+	languages := projectLanguages(content)
+
+	for _, lang := range []struct {
+		Name, EnvVar, Label string
+		Find                func() (compilerInfo, bool)
+	}{
+		{"CXX", "CXX", "C++", findCXXCompiler},
+		{"C", "CC", "C", findCCompiler},
+	} {
+		required := containsWord(languages, lang.Name)
+		if value := strings.Fields(os.Getenv(lang.EnvVar)); len(value) > 0 {
+			if _, err := exec.LookPath(value[0]); err != nil {
+				message := fmt.Sprintf("%s is set to '%s', which is not found", lang.EnvVar, os.Getenv(lang.EnvVar))
+				fix := "install it, or unset " + lang.EnvVar + " to use the default compiler"
+				if required {
+					r.fail(message, fix)
+				} else {
+					r.note(message+", the project does not need it", fix)
+				}
+				continue
+			}
+		}
+
+		compiler, ok := lang.Find()
+		switch {
+		case !ok && required:
+			r.fail(fmt.Sprintf("no %s compiler found", lang.Label), installHint("compiler")+", or set "+lang.EnvVar)
+		case !ok:
+			r.note(fmt.Sprintf("no %s compiler found, the project does not need one", lang.Label), "")
+		case compiler.ID == "":
+			r.warn(fmt.Sprintf("%s compiler %s is not GCC or Clang", lang.Label, compiler.Path()),
+				"qs checks and sanitizer, coverage and standard support assume GCC or Clang")
+			found[lang.Name] = compiler
+		default:
+			r.ok("%s compiler %s", lang.Label, compiler)
+			found[lang.Name] = compiler
+		}
+	}
+
+	if cxx, ok := found["CXX"]; ok {
+		if c, ok := found["C"]; ok && c.ID != "" && cxx.ID != "" && (c.ID != cxx.ID || c.Version != cxx.Version) {
+			r.warn(fmt.Sprintf("the C compiler (%s %s) and C++ compiler (%s %s) differ", c.ID, c.Version, cxx.ID, cxx.Version),
+				"set CC and CXX to the same compiler family, e.g. CC=gcc CXX=g++")
+		}
+	}
+	return found
+}
+
+// checkStandards checks that CMake and the compilers support the language
+// standards the project sets
+func checkStandards(r *doctorReport, content string, cmakeVersion string, compilers map[string]compilerInfo) {
+	type request struct{ Lang, Label, Value, Ext, EnvVar string }
+	var requests []request
+	if value := getCMakeVariable(content, "CMAKE_CXX_STANDARD"); value != "" {
+		requests = append(requests, request{"CXX", "C++", value, ".cpp", "CXX"})
+	}
+	if value := getCMakeVariable(content, "CMAKE_C_STANDARD"); value != "" {
+		requests = append(requests, request{"C", "C", value, ".c", "CC"})
+	}
+	if len(requests) == 0 {
+		return
+	}
+
+	r.section("Language standards")
+	for _, req := range requests {
+		name := req.Label + req.Value
+		flags := standardFlags(req.Lang, req.Value)
+		if flags == nil {
+			r.fail(fmt.Sprintf("CMAKE_%s_STANDARD is %s, which is not a %s standard", req.Lang, req.Value, req.Label),
+				"set a valid standard with 'qs std'")
+			continue
+		}
+
+		if needed := cmakeStandardVersions[req.Lang][req.Value]; needed != "" && cmakeVersion != "" && !versionAtLeast(cmakeVersion, needed) {
+			r.fail(fmt.Sprintf("%s needs CMake %s, found %s", name, needed, cmakeVersion),
+				"install CMake "+needed+" or later: "+installHint("cmake"))
+		}
+
+		compiler, ok := compilers[req.Lang]
+		if !ok {
+			continue
+		}
+		if flag := supportedStandardFlag(compiler, req.Ext, flags); flag != "" {
+			r.ok("%s supported by %s (%s)", name, compiler.Path(), flag)
+		} else {
+			r.fail(fmt.Sprintf("%s is not supported by %s", name, compiler),
+				"use a newer compiler (set "+req.EnvVar+") or an older standard with 'qs std'")
+		}
+	}
+}
+
+// checkOptionalTools reports the tools that speed up or extend builds
+func checkOptionalTools(r *doctorReport) {
+	r.section("Optional tools")
+	launcher, err := detectLauncher()
+	switch {
+	case err != nil:
+		r.fail(err.Error(), "install it, or set it to auto")
+	case launcher == "" && os.Getenv("QS_LAUNCHER") == "" && settings.Build.Launcher == "":
+		r.note("no compiler cache installed, rebuilds after switching branches compile everything", installHint("ccache"))
+	case launcher == "":
+		r.ok("compiler cache disabled by QS_LAUNCHER or build.launcher")
+	default:
+		path, _ := exec.LookPath(launcher)
+		r.ok("%s", describeTool(launcher, path))
+	}
+
+	if _, err := exec.LookPath("llvm-symbolizer"); err == nil {
+		r.ok("llvm-symbolizer")
+	} else if path := findSymbolizer(); path != "" {
+		r.ok("%s, used for sanitizer stack traces", filepath.Base(path))
+	} else {
+		r.note("llvm-symbolizer not found, sanitizer reports will show addresses instead of source lines", "install LLVM (e.g. sudo apt install llvm)")
+	}
+
+	// Cross compilers of the project's toolchains
+	files, _ := filepath.Glob(filepath.Join(toolchainDir, "*.cmake"))
+	for _, file := range files {
+		for _, variable := range []string{"CMAKE_C_COMPILER", "CMAKE_CXX_COMPILER"} {
+			compiler := readToolchainSetting(file, variable)
+			if compiler == "" {
+				continue
+			}
+			if _, err := exec.LookPath(compiler); err != nil {
+				r.warn(fmt.Sprintf("toolchain %s: %s not found", toolchainName(file), compiler),
+					"install the cross compiler, or fix "+variable+" in "+filepath.ToSlash(file))
+			} else {
+				r.ok("toolchain %s: %s", toolchainName(file), compiler)
+			}
+		}
+	}
+}
+
+// checkBuildDirectory checks the last used build directory still matches the
+// installed tools
+func checkBuildDirectory(r *doctorReport) {
+	buildDir := activeBuildDir()
+	if buildDir == "" {
+		return
+	}
+	cachePath := filepath.Join(buildDir, "CMakeCache.txt")
+
+	r.section("Build directory " + relativePath(buildDir))
+	problems := 0
+	for _, variable := range []string{"CMAKE_CXX_COMPILER", "CMAKE_C_COMPILER", "CMAKE_MAKE_PROGRAM", "CMAKE_COMMAND"} {
+		value := readCacheValue(cachePath, variable)
+		if value == "" || filepath.IsAbs(value) && fileExists(value) {
+			continue
+		}
+		if _, err := exec.LookPath(value); err == nil {
+			continue
+		}
+		r.fail(fmt.Sprintf("%s %s no longer exists", variable, value), "remove the build directories with 'qs distclean', then build again")
+		problems++
+	}
+	if problems == 0 {
+		r.ok("configured with tools that are still installed")
+	}
+}
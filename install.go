@@ -0,0 +1,460 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// installRulesSection is the name of the managed section holding install rules
+const installRulesSection = "install-rules"
+
+var (
+	// legacyInstallRegex matches the install rule older versions of 'qs std' wrote
+	legacyInstallRegex = regexp.MustCompile(`\n*# Add install target\n(install\(TARGETS[^)]*DESTINATION bin\)|# No targets found to install)[ \t]*\n?`)
+	// legacySubInstallRegex matches the install rules older versions of 'qs init sub' wrote
+	legacySubInstallRegex = regexp.MustCompile(`\n*# Install rules\ninstall\(TARGETS (\S+)\n    ARCHIVE DESTINATION lib\n    LIBRARY DESTINATION lib\n    RUNTIME DESTINATION bin\n\)\ninstall\(DIRECTORY include/ DESTINATION include\)\n?`)
+	installTargetsRegex   = regexp.MustCompile(`install\(\s*TARGETS\s([^)]*)\)`)
+	installDirectoryRegex = regexp.MustCompile(`install\(\s*DIRECTORY\s+(\S+)`)
+)
+
+// installPlan is what the managed install section covers
+type installPlan struct {
+	Targets     []cmakeTarget
+	HeaderDirs  []string // include directories relative to the project root
+	RuntimeDeps bool     // whether any target can have shared library dependencies
+	Skipped     []string // notes about things installed by user-written rules
+}
+
+// planInstallRules decides what the managed install section should install.
+// Targets and header directories already installed by rules outside the
+// managed section are left alone.
+func planInstallRules(model projectModel) installPlan {
+	var plan installPlan
+
+	installedTargets := map[string]string{}
+	installedDirs := map[string]string{}
+	for _, dir := range model.Dirs {
+		cmakePath := filepath.Join(dir, "CMakeLists.txt")
+		data, err := os.ReadFile(cmakePath)
+		if err != nil {
+			continue
+		}
+		content := stripCMakeComments(withoutManagedSection(string(data), installRulesSection))
+
+		for _, match := range installTargetsRegex.FindAllStringSubmatch(content, -1) {
+			for _, arg := range strings.Fields(match[1]) {
+				if isInstallKeyword(arg) {
+					break
+				}
+				installedTargets[arg] = cmakePath
+			}
+		}
+		for _, match := range installDirectoryRegex.FindAllStringSubmatch(content, -1) {
+			installedDirs[filepath.Clean(filepath.Join(dir, strings.Trim(match[1], `"`)))] = cmakePath
+		}
+	}
+
+	headerDirs := map[string]bool{}
+	addHeaderDir := func(dir string) {
+		includeDir := filepath.Clean(filepath.Join(dir, "include"))
+		if headerDirs[includeDir] || !isDir(includeDir) {
+			return
+		}
+		headerDirs[includeDir] = true
+		if file, ok := installedDirs[includeDir]; ok {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("headers in %s/ (installed by %s)", includeDir, file))
+			return
+		}
+		plan.HeaderDirs = append(plan.HeaderDirs, filepath.ToSlash(includeDir))
+	}
+
+	addHeaderDir(".")
+	for _, target := range model.Targets {
+		if target.Kind == kindCustom || target.Type == "OBJECT" {
+			continue
+		}
+		if file, ok := installedTargets[target.Name]; ok {
+			plan.Skipped = append(plan.Skipped, fmt.Sprintf("target %s (installed by %s)", target.Name, file))
+		} else {
+			plan.Targets = append(plan.Targets, target)
+			if target.Kind == kindExecutable || (target.Type != "STATIC" && target.Type != "INTERFACE") {
+				plan.RuntimeDeps = true
+			}
+		}
+		if target.Kind == kindLibrary {
+			addHeaderDir(target.Dir)
+		}
+	}
+
+	return plan
+}
+
+// renderInstallRules generates the body of the managed install section
+func renderInstallRules(plan installPlan) string {
+	if len(plan.Targets) == 0 && len(plan.HeaderDirs) == 0 {
+		return "# No targets to install\n"
+	}
+
+	var b strings.Builder
+	b.WriteString("include(GNUInstallDirs)\n")
+
+	destinations := `        RUNTIME DESTINATION ${CMAKE_INSTALL_BINDIR}
+        LIBRARY DESTINATION ${CMAKE_INSTALL_LIBDIR}
+        ARCHIVE DESTINATION ${CMAKE_INSTALL_LIBDIR}
+        PUBLIC_HEADER DESTINATION ${CMAKE_INSTALL_INCLUDEDIR}
+`
+
+	if len(plan.Targets) > 0 {
+		b.WriteString("\nset(QS_INSTALL_TARGETS\n")
+		for _, target := range plan.Targets {
+			b.WriteString("    " + target.Name + "\n")
+		}
+		b.WriteString(")\n\n")
+
+		if plan.RuntimeDeps {
+			// Runtime dependency sets need CMake 3.21 and a native build
+			b.WriteString("if(CMAKE_VERSION VERSION_GREATER_EQUAL 3.21 AND NOT CMAKE_CROSSCOMPILING)\n")
+			b.WriteString("    # Also install shared libraries the targets need at runtime,\n")
+			b.WriteString("    # except those that come with the operating system\n")
+			b.WriteString("    install(TARGETS ${QS_INSTALL_TARGETS}\n")
+			b.WriteString("        RUNTIME_DEPENDENCY_SET qs_runtime_dependencies\n")
+			b.WriteString(destinations)
+			b.WriteString("    )\n")
+			b.WriteString(`    install(RUNTIME_DEPENDENCY_SET qs_runtime_dependencies
+        PRE_EXCLUDE_REGEXES "api-ms-.*" "ext-ms-.*"
+        POST_EXCLUDE_REGEXES ".*system32/.*\\.dll" "^/lib" "^/usr/lib" "^/System/Library"
+        RUNTIME DESTINATION ${CMAKE_INSTALL_BINDIR}
+        LIBRARY DESTINATION ${CMAKE_INSTALL_LIBDIR}
+    )
+`)
+			b.WriteString("else()\n")
+			b.WriteString("    install(TARGETS ${QS_INSTALL_TARGETS}\n")
+			b.WriteString(destinations)
+			b.WriteString("    )\n")
+			b.WriteString("endif()\n")
+		} else {
+			b.WriteString("install(TARGETS ${QS_INSTALL_TARGETS}\n")
+			b.WriteString(strings.ReplaceAll(destinations, "        ", "    "))
+			b.WriteString(")\n")
+		}
+	}
+
+	if len(plan.HeaderDirs) > 0 {
+		b.WriteString("\n")
+		for _, dir := range plan.HeaderDirs {
+			b.WriteString(fmt.Sprintf("install(DIRECTORY %s/ DESTINATION ${CMAKE_INSTALL_INCLUDEDIR})\n", dir))
+		}
+	}
+
+	return b.String()
+}
+
+// removeLegacyInstallRules drops install rules that older versions of qs wrote,
+// now that the managed section covers them
+func removeLegacyInstallRules(model projectModel) {
+	for _, dir := range model.Dirs {
+		cmakePath := filepath.Join(dir, "CMakeLists.txt")
+		data, err := os.ReadFile(cmakePath)
+		if err != nil {
+			continue
+		}
+		content := string(data)
+		updated := legacyInstallRegex.ReplaceAllString(content, "\n")
+		updated = legacySubInstallRegex.ReplaceAllStringFunc(updated, func(block string) string {
+			// Only the sub-project's own generated rules
+			if legacySubInstallRegex.FindStringSubmatch(block)[1] != filepath.Base(dir) {
+				return block
+			}
+			return "\n"
+		})
+		if updated != content {
+			if err := os.WriteFile(cmakePath, []byte(updated), 0644); err != nil {
+				fmt.Printf("Error updating %s: %v\n", cmakePath, err)
+				continue
+			}
+			fmt.Printf("Replaced old install rules in %s\n", cmakePath)
+		}
+	}
+}
+
+// generateInstallRules writes the managed install section to the root CMakeLists.txt
+func generateInstallRules() {
+	model, err := loadProject()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		fmt.Println("Run 'qs init' to create a new CMake project.")
+		return
+	}
+
+	removeLegacyInstallRules(model)
+	plan := planInstallRules(model)
+
+	cmakelists, err := os.ReadFile("CMakeLists.txt")
+	if err != nil {
+		fmt.Printf("Error reading CMakeLists.txt: %v\n", err)
+		return
+	}
+	content := setInstallSection(string(cmakelists), renderInstallRules(plan))
+	err = os.WriteFile("CMakeLists.txt", []byte(content), 0644)
+	if err != nil {
+		fmt.Printf("Error updating CMakeLists.txt: %v\n", err)
+		return
+	}
+
+	fmt.Printf("Updated install rules: %d target(s), %d header folder(s)\n", len(plan.Targets), len(plan.HeaderDirs))
+	for _, target := range plan.Targets {
+		kind := target.Kind
+		if target.Type != "" {
+			kind = strings.ToLower(target.Type) + " " + kind
+		}
+		fmt.Printf("  %s (%s)\n", target.Name, kind)
+	}
+	for _, dir := range plan.HeaderDirs {
+		fmt.Printf("  %s/ -> include\n", dir)
+	}
+	if len(plan.Skipped) > 0 {
+		fmt.Println("Left to existing install rules:")
+		for _, note := range plan.Skipped {
+			fmt.Printf("  %s\n", note)
+		}
+	}
+}
+
+// refreshInstallRules regenerates the managed install section after targets
+// were added or removed. Projects without the section are left untouched.
+func refreshInstallRules() {
+	cmakelists, err := os.ReadFile("CMakeLists.txt")
+	if err != nil || !hasManagedSection(string(cmakelists), installRulesSection) {
+		return
+	}
+
+	model, err := loadProject()
+	if err != nil {
+		return
+	}
+	content := setInstallSection(string(cmakelists), renderInstallRules(planInstallRules(model)))
+	if content == string(cmakelists) {
+		return
+	}
+	err = os.WriteFile("CMakeLists.txt", []byte(content), 0644)
+	if err != nil {
+		fmt.Printf("Error updating install rules: %v\n", err)
+		return
+	}
+	fmt.Println("Updated install rules")
+}
+
+// setInstallSection writes the managed install section at the end of the file,
+// where every target it names has already been defined
+func setInstallSection(content string, body string) string {
+	return setManagedSection(withoutManagedSection(content, installRulesSection), installRulesSection, body)
+}
+
+// hasInstallRules reports whether the root CMakeLists.txt has a managed install section
+func hasInstallRules() bool {
+	cmakelists, err := os.ReadFile("CMakeLists.txt")
+	return err == nil && hasManagedSection(string(cmakelists), installRulesSection)
+}
+
+// installManifestFile is the committed list of files 'qs install --check' expects
+const installManifestFile = "install-manifest.txt"
+
+// installOptions holds the arguments of 'qs install'
+type installOptions struct {
+	Prefix         string       // install prefix, a staging directory inside the build tree by default
+	Build          buildOptions // build directory to install, the last built one if none is selected
+	Check          bool         // compare the installed files to the committed manifest
+	UpdateManifest bool         // write the installed files to the committed manifest
+}
+
+// parseInstallArgs parses the arguments following 'qs install'
+func parseInstallArgs(args []string) (installOptions, error) {
+	opts := installOptions{Prefix: settings.Install.Prefix}
+
+	sel, args, err := extractBuildSelection(args)
+	if err != nil {
+		return opts, err
+	}
+	opts.Build = sel
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			parts := strings.SplitN(arg, "=", 2)
+			arg, value = parts[0], parts[1]
+		}
+
+		switch arg {
+		case "--prefix":
+			if value == "" {
+				if i+1 >= len(args) {
+					return opts, fmt.Errorf("'%s' requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			opts.Prefix = value
+		case "--check":
+			opts.Check = true
+		case "--update-manifest":
+			opts.UpdateManifest = true
+		default:
+			return opts, fmt.Errorf("unknown option '%s'", arg)
+		}
+	}
+
+	return opts, nil
+}
+
+// installProject runs 'cmake --install' into a staging directory and
+// prints or checks the list of installed files
+func installProject(opts installOptions) bool {
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("Error getting current directory: %s\n", err)
+		return false
+	}
+
+	relBuildDir, err := selectBuildDir(opts.Build)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		return false
+	}
+	buildDir := filepath.Join(cwd, relBuildDir)
+	buildType := readCacheValue(filepath.Join(buildDir, "CMakeCache.txt"), "CMAKE_BUILD_TYPE")
+
+	prefix := opts.Prefix
+	if prefix == "" {
+		// The default staging directory belongs to qs, so start from scratch
+		// to get an accurate manifest
+		prefix = filepath.Join(buildDir, "stage")
+		if err := os.RemoveAll(prefix); err != nil {
+			fmt.Printf("Error cleaning staging directory: %s\n", err)
+			return false
+		}
+	}
+	prefix, err = filepath.Abs(prefix)
+	if err != nil {
+		fmt.Printf("Error resolving prefix: %s\n", err)
+		return false
+	}
+
+	args := []string{"--install", buildDir, "--prefix", prefix}
+	if buildType != "" {
+		// Needed by multi-config generators, harmless otherwise
+		args = append(args, "--config", buildType)
+	}
+
+	fmt.Printf("Installing into %s...\n", prefix)
+	cmd := exec.Command("cmake", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Printf("Error running cmake --install: %s\n", err)
+		return false
+	}
+
+	manifest, err := readInstallManifest(buildDir, prefix)
+	if err != nil {
+		fmt.Printf("Error reading install manifest: %s\n", err)
+		return false
+	}
+
+	fmt.Printf("\nInstalled files (%d):\n", len(manifest))
+	for _, file := range manifest {
+		fmt.Printf("  %s\n", file)
+	}
+
+	if opts.UpdateManifest {
+		content := strings.Join(manifest, "\n") + "\n"
+		if err := os.WriteFile(installManifestFile, []byte(content), 0644); err != nil {
+			fmt.Printf("Error writing %s: %s\n", installManifestFile, err)
+			return false
+		}
+		fmt.Printf("\nWrote %s, commit it so 'qs install --check' can compare against it\n", installManifestFile)
+	}
+
+	if opts.Check {
+		return checkInstallManifest(manifest)
+	}
+	return true
+}
+
+// readInstallManifest returns the files the last install wrote, relative to
+// the prefix and sorted. CMake records them in install_manifest.txt.
+func readInstallManifest(buildDir string, prefix string) ([]string, error) {
+	data, err := os.ReadFile(filepath.Join(buildDir, "install_manifest.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if rel, err := filepath.Rel(prefix, line); err == nil && !strings.HasPrefix(rel, "..") {
+			line = rel
+		}
+		files = append(files, filepath.ToSlash(line))
+	}
+	sort.Strings(files)
+	return removeDuplicates(files), nil
+}
+
+// checkInstallManifest compares installed files with the committed manifest
+func checkInstallManifest(installed []string) bool {
+	data, err := os.ReadFile(installManifestFile)
+	if err != nil {
+		fmt.Printf("\nError: %s not found.\n", installManifestFile)
+		fmt.Println("Run 'qs install --update-manifest' and commit the result.")
+		return false
+	}
+
+	expected := map[string]bool{}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			expected[line] = true
+		}
+	}
+	actual := map[string]bool{}
+	for _, file := range installed {
+		actual[file] = true
+	}
+
+	var missing, unexpected []string
+	for file := range expected {
+		if !actual[file] {
+			missing = append(missing, file)
+		}
+	}
+	for _, file := range installed {
+		if !expected[file] {
+			unexpected = append(unexpected, file)
+		}
+	}
+	sort.Strings(missing)
+
+	if len(missing) == 0 && len(unexpected) == 0 {
+		fmt.Printf("\nInstalled files match %s\n", installManifestFile)
+		return true
+	}
+
+	fmt.Printf("\nInstalled files differ from %s:\n", installManifestFile)
+	for _, file := range missing {
+		fmt.Printf("  - %s (no longer installed)\n", file)
+	}
+	for _, file := range unexpected {
+		fmt.Printf("  + %s (not in manifest)\n", file)
+	}
+	fmt.Println("If the change is intended, run 'qs install --update-manifest' and commit the result.")
+	return false
+}
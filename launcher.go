@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// compilerLaunchers lists the compiler caches qs detects, in order of preference
+var compilerLaunchers = []string{"ccache", "sccache"}
+
+// compileLauncherVariables are the cache variables CMake runs the compilers through
+var compileLauncherVariables = []string{"CMAKE_C_COMPILER_LAUNCHER", "CMAKE_CXX_COMPILER_LAUNCHER"}
+
+// linkLauncherVariables are the cache variables CMake 3.21 and later runs the
+// linkers through
+var linkLauncherVariables = []string{"CMAKE_C_LINKER_LAUNCHER", "CMAKE_CXX_LINKER_LAUNCHER"}
+
+// detectLauncher returns the compiler cache to build with, or an empty string
+// for none. The first of ccache and sccache on PATH is used unless the
+// QS_LAUNCHER environment variable or build.launcher in the settings names
+// one, or is "none" to opt out.
+func detectLauncher() (string, error) {
+	choice, source := strings.ToLower(strings.TrimSpace(os.Getenv("QS_LAUNCHER"))), "QS_LAUNCHER"
+	if choice == "" {
+		choice, source = settings.Build.Launcher, "build.launcher"
+	}
+	switch choice {
+	case "", "auto":
+		for _, name := range compilerLaunchers {
+			if _, err := exec.LookPath(name); err == nil {
+				return name, nil
+			}
+		}
+		return "", nil
+	case "none", "off":
+		return "", nil
+	}
+
+	if !containsWord(compilerLaunchers, choice) {
+		return "", fmt.Errorf("unknown compiler launcher '%s' in %s (expected auto, ccache, sccache or none)", choice, source)
+	}
+	if _, err := exec.LookPath(choice); err != nil {
+		return "", fmt.Errorf("%s is %s, but %s is not installed", source, choice, choice)
+	}
+	return choice, nil
+}
+
+// launcherArgs returns the cmake arguments that make the compilers run
+// through launcher, and the compilers and linkers through wrapper (the
+// timing recorder). Launcher variables that are not needed are removed, so
+// switching a launcher off takes effect. Launchers set with -D or qs config
+// are left alone.
+func launcherArgs(launcher string, wrapper []string, userVars []cacheEntry) []string {
+	compile := append([]string{}, wrapper...)
+	if launcher != "" {
+		compile = append(compile, launcher)
+	}
+
+	var args []string
+	set := func(name string, command []string) {
+		if _, ok := findCacheEntry(userVars, name); ok {
+			return
+		}
+		if len(command) > 0 {
+			args = append(args, "-D"+name+"="+strings.Join(command, ";"))
+		} else {
+			args = append(args, "-U"+name)
+		}
+	}
+	for _, name := range compileLauncherVariables {
+		set(name, compile)
+	}
+	for _, name := range linkLauncherVariables {
+		set(name, wrapper)
+	}
+	return args
+}
+
+// launcherStats returns the number of cache hits and misses a compiler cache
+// has counted so far
+func launcherStats(launcher string) (int64, int64, bool) {
+	switch launcher {
+	case "ccache":
+		// Tab separated "name<TAB>value" lines, ccache 4 and later
+		output, err := exec.Command("ccache", "--print-stats").Output()
+		if err != nil {
+			return 0, 0, false
+		}
+		var hits, misses int64
+		scanner := bufio.NewScanner(strings.NewReader(string(output)))
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), "\t")
+			if len(fields) != 2 {
+				continue
+			}
+			value, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch fields[0] {
+			case "direct_cache_hit", "preprocessed_cache_hit":
+				hits += value
+			case "cache_miss":
+				misses += value
+			}
+		}
+		return hits, misses, true
+
+	case "sccache":
+		output, err := exec.Command("sccache", "--show-stats", "--stats-format=json").Output()
+		if err != nil {
+			return 0, 0, false
+		}
+		var stats struct {
+			Stats struct {
+				CacheHits struct {
+					Counts map[string]int64 `json:"counts"`
+				} `json:"cache_hits"`
+				CacheMisses struct {
+					Counts map[string]int64 `json:"counts"`
+				} `json:"cache_misses"`
+			} `json:"stats"`
+		}
+		if err := json.Unmarshal(output, &stats); err != nil {
+			return 0, 0, false
+		}
+		var hits, misses int64
+		for _, count := range stats.Stats.CacheHits.Counts {
+			hits += count
+		}
+		for _, count := range stats.Stats.CacheMisses.Counts {
+			misses += count
+		}
+		return hits, misses, true
+	}
+	return 0, 0, false
+}
+
+// printLauncherStats prints the hits and misses of the compiler cache during
+// a build, from its statistics before and after
+func printLauncherStats(launcher string, hitsBefore int64, missesBefore int64) {
+	hits, misses, ok := launcherStats(launcher)
+	if !ok {
+		return
+	}
+	hits -= hitsBefore
+	misses -= missesBefore
+	if hits+misses == 0 {
+		fmt.Printf("Compiler cache (%s): nothing compiled\n", launcher)
+		return
+	}
+	fmt.Printf("Compiler cache (%s): %d hits, %d misses (%s hit rate)\n",
+		launcher, hits, misses, percent(int(hits), int(hits+misses)))
+}
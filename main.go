@@ -5,9 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"runtime"
-	"strconv"
 	"strings"
 )
 
@@ -18,12 +16,60 @@ func printHelp() {
 	fmt.Println("Usage:")
 	fmt.Println("  qs init                   Initialize a new CMake project")
 	fmt.Println("  qs init sub <name>        Create a subdirectory with CMakeLists.txt for a sub-project")
+	fmt.Println("    [--layout flat|namespaced]  Header layout: include/<name>.h or include/<name>/<name>.hpp")
+	fmt.Println("  qs rm sub <name> [--yes]  Remove a sub-project and the references qs added for it")
+	fmt.Println("  qs mv sub <old> <new>     Rename a sub-project, its target, namespace and headers")
 	fmt.Println("  qs add <target> [files]   Add executable or library target")
 	fmt.Println("                            [files] can include glob patterns like *.cpp")
-	fmt.Println("  qs std [cxx_std]          Add standard CMake configuration with optional C++ standard (11/14/17/20)")
-	fmt.Println("  qs build                  Create build directory, run cmake and make")
+	fmt.Println("  qs std [cxx_std]          Add standard CMake configuration with optional C++ standard (98/03/11/14/17/20/23/26)")
+	fmt.Println("    [--c 90|99|11|17|23]    Also set the C standard")
+	fmt.Println("    [--extensions on|off]   Enable or disable compiler extensions (e.g. gnu++20)")
+	fmt.Println("    [--target <target>]     Set the standard on one target with target_compile_features")
+	fmt.Println("    [--verify]              Check which standards and features the installed compilers support")
+	fmt.Println("  qs install-rules          Generate or refresh the managed install rules for all targets and headers")
+	fmt.Println("  qs install                Install the built project into a staging directory and list the files")
+	fmt.Println("    [--prefix <dir>]        Install into <dir> instead of <build dir>/stage")
+	fmt.Println("    [--release|--config <config>]  Configuration to install (default: last built)")
+	fmt.Println("    [--check]               Compare the installed files with install-manifest.txt")
+	fmt.Println("    [--update-manifest]     Write the installed files to install-manifest.txt")
+	fmt.Println("  qs package                Build the project and create packages with CPack")
+	fmt.Println("    [--format tgz|zip|deb|rpm]  Package format (default tgz)")
+	fmt.Println("    [--vendor <name>] [--contact <maintainer>]  Package vendor and maintainer")
+	fmt.Println("    [--debug|--release|...]  Configuration to build and package (default Release)")
+	fmt.Println("  qs build [targets]        Create build directory, run cmake and build the project (or only the given targets)")
+	fmt.Println("    [--debug|--release|--relwithdebinfo|--minsizerel]  Build configuration, built in build/<config>")
+	fmt.Println("    [-G <generator>]        CMake generator for a new build directory (default: Ninja if installed)")
+	fmt.Println("    [-j|--parallel <n>]     Number of parallel jobs (default: number of CPUs)")
+	fmt.Println("    [--reconfigure]         Run CMake even if no CMake input changed")
+	fmt.Println("    [-D NAME[:TYPE]=VALUE]  Set a CMake cache option, kept for later builds (repeatable)")
+	fmt.Println("    [--toolchain <name>]    Cross-compile with cmake/toolchains/<name>.cmake in build/<name>-<config>")
+	fmt.Println("    [--compiler <c>]        Build with gcc, clang or the compiler at a path, in build/<compiler>-<config>")
+	fmt.Println("    [--matrix <list>]       Build with each of a comma separated list of compilers side by side, e.g. gcc,clang")
+	fmt.Println("    [--sanitize <list>]     Build with sanitizers (address, undefined, thread, memory) in their own build directory")
+	fmt.Println("    [--timings]             Report the slowest compile and link steps, time per target and the critical path")
+	fmt.Println("    [--trace <file>]        Also write the build steps as a Chrome trace (implies --timings)")
+	fmt.Println("    [--diagnostics json|sarif]  Also write compiler diagnostics to the build directory")
+	fmt.Println("    [--profile <name>]      Build with the options of a [profile.<name>] table in qs.toml")
+	fmt.Println("  qs rebuild [targets]      Clean, then build; takes the same options as qs build")
+	fmt.Println("  qs watch [targets]        Build, then rebuild whenever a source, header or CMake file changes; takes the same options as qs build")
+	fmt.Println("  qs clean                  Remove the build outputs of the last built (or selected) configuration")
+	fmt.Println("  qs distclean [--yes]      Remove all build directories created by qs")
+	fmt.Println("  qs toolchain new <name>   Write a CMake toolchain file to cmake/toolchains/<name>.cmake")
+	fmt.Println("    --cc <compiler> --cxx <compiler> [--sysroot <dir>] [--system-name Linux] [--processor aarch64]")
+	fmt.Println("  qs toolchain list         List the toolchains of the project")
+	fmt.Println("  qs compdb                 Regenerate compile_commands.json and link it into the project root")
+	fmt.Println("  qs config get <name>      Print a CMake cache entry of the build directory")
+	fmt.Println("  qs config set <name>[:<type>] <value>  Set a cache entry (BOOL, STRING, PATH, FILEPATH)")
+	fmt.Println("  qs config unset <name>    Remove a cache entry")
+	fmt.Println("  qs config list [--all]    List cache entries (--all includes advanced and internal ones)")
 	fmt.Println("  qs run [target]           Run the specified executable target (or default target if not specified)")
-	fmt.Println("  qs list                   List all available targets in the project")
+	fmt.Println("    [--watch]               Rebuild and restart the program whenever a project file changes; takes the options of qs build")
+	fmt.Println("  qs test [ctest args]      Run the project's tests with ctest")
+	fmt.Println("  qs coverage               Build with coverage instrumentation, run the tests and report line and branch coverage")
+	fmt.Println("  qs list [--names]         List all available targets in the project (--names: just the names)")
+	fmt.Println("                            run, test and list use the last built configuration unless one is selected (also with --sanitize, --toolchain or --compiler)")
+	fmt.Println("  qs doctor                 Check CMake, build tools and compilers, and print how to fix problems")
+	fmt.Println("                            Defaults are read from qs.toml in the project and ~/.config/qs/config.toml")
 	fmt.Println("  qs doc                    Open CMake documentation in the default browser")
 	fmt.Println("  qs version                Show version information")
 	fmt.Println("  qs help                   Show this help message")
@@ -37,15 +83,40 @@ func main() {
 
 	command := os.Args[1]
 
+	// The timing wrapper runs for every compile and needs no settings
+	if command != timingCommand && command != "help" && command != "version" {
+		if err := loadSettings(); err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+	}
+
 	switch command {
 	case "init":
 		if len(os.Args) > 2 && os.Args[2] == "sub" {
-			if len(os.Args) < 4 {
+			subDirName := ""
+			layout := ""
+			for i := 3; i < len(os.Args); i++ {
+				arg := os.Args[i]
+				switch {
+				case arg == "--layout":
+					if i+1 >= len(os.Args) {
+						fmt.Println("Error: '--layout' requires a value (flat or namespaced)")
+						return
+					}
+					i++
+					layout = os.Args[i]
+				case strings.HasPrefix(arg, "--layout="):
+					layout = strings.TrimPrefix(arg, "--layout=")
+				default:
+					subDirName = arg
+				}
+			}
+			if subDirName == "" {
 				fmt.Println("Error: 'init sub' requires a subdirectory name")
 				return
 			}
-			subDirName := os.Args[3]
-			initSubProject(subDirName)
+			initSubProject(subDirName, layout)
 		} else {
 			initProject()
 		}
@@ -60,27 +131,159 @@ func main() {
 			sourceFiles = os.Args[3:]
 		}
 		addTarget(targetName, sourceFiles)
-	case "std":
-		cxxStd := 0
-		if len(os.Args) > 2 {
-			val, err := strconv.Atoi(os.Args[2])
-			if err == nil && (val == 11 || val == 14 || val == 17 || val == 20) {
-				cxxStd = val
+	case "rm":
+		if len(os.Args) < 4 || os.Args[2] != "sub" {
+			fmt.Println("Error: usage is 'qs rm sub <name> [--yes]'")
+			return
+		}
+		assumeYes := false
+		name := ""
+		for _, arg := range os.Args[3:] {
+			if arg == "-y" || arg == "--yes" {
+				assumeYes = true
 			} else {
-				fmt.Println("Warning: Invalid C++ standard, using default")
+				name = arg
 			}
 		}
-		addStandardConfig(cxxStd)
+		if name == "" {
+			fmt.Println("Error: 'rm sub' requires a sub-project name")
+			return
+		}
+		removeSubProject(name, assumeYes)
+	case "mv":
+		if len(os.Args) < 5 || os.Args[2] != "sub" {
+			fmt.Println("Error: usage is 'qs mv sub <old_name> <new_name>'")
+			return
+		}
+		renameSubProject(os.Args[3], os.Args[4])
+	case "std":
+		opts, err := parseStandardArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		addStandardConfig(opts)
+	case "install-rules":
+		generateInstallRules()
+	case "install":
+		opts, err := parseInstallArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if !installProject(opts) {
+			os.Exit(1)
+		}
+	case "package":
+		opts, err := parsePackageArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if !packageProject(opts) {
+			os.Exit(1)
+		}
 	case "build":
-		buildProject()
+		opts, err := parseBuildArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		warnUnknownTargets(opts.Targets)
+		if !buildProject(opts) {
+			os.Exit(1)
+		}
+	case "rebuild":
+		opts, err := parseBuildArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		opts.Clean = true
+		warnUnknownTargets(opts.Targets)
+		if !buildProject(opts) {
+			os.Exit(1)
+		}
+	case "watch":
+		opts, err := parseBuildArgs(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		watchProject(opts)
+	case "clean":
+		sel, args, err := extractBuildSelection(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if len(args) > 0 {
+			fmt.Printf("Error: unknown option '%s'\n", args[0])
+			return
+		}
+		cleanProject(sel)
+	case "distclean":
+		assumeYes := false
+		for _, arg := range os.Args[2:] {
+			if arg != "-y" && arg != "--yes" {
+				fmt.Printf("Error: unknown option '%s'\n", arg)
+				return
+			}
+			assumeYes = true
+		}
+		distcleanProject(assumeYes)
 	case "run":
-		targetName := ""
-		if len(os.Args) > 2 {
-			targetName = os.Args[2]
+		if containsWord(os.Args[2:], "--watch") {
+			var args []string
+			for _, arg := range os.Args[2:] {
+				if arg != "--watch" {
+					args = append(args, arg)
+				}
+			}
+			opts, err := parseBuildArgs(args)
+			if err != nil {
+				fmt.Printf("Error: %s\n", err)
+				return
+			}
+			runWatch(opts)
+			return
 		}
-		runProject(targetName)
+		sel, args, err := extractBuildSelection(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		targetName := settings.Run.Target
+		if len(args) > 0 {
+			targetName = args[0]
+		}
+		runProject(targetName, sel)
+	case "test":
+		sel, args, err := extractBuildSelection(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		testProject(sel, append(append([]string{}, settings.Test.Args...), args...))
+	case "config":
+		configCommand(os.Args[2:])
+	case "toolchain":
+		toolchainCommand(os.Args[2:])
+	case "coverage":
+		coverageCommand(os.Args[2:])
+	case timingCommand:
+		runTimed(os.Args[2:])
+	case "compdb":
+		compdbCommand(os.Args[2:])
 	case "list":
-		listTargets()
+		sel, args, err := extractBuildSelection(os.Args[2:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		listTargets(sel, len(args) > 0 && args[0] == "--names")
+	case "doctor":
+		doctorCommand(os.Args[2:])
 	case "doc":
 		openDocumentation()
 	case "version":
@@ -93,8 +296,11 @@ func main() {
 	}
 }
 
-// listTargets finds and lists all targets in the project
-func listTargets() {
+// listTargets finds and lists all targets in the project, and the executables
+// built for the selected (or most recently built) configuration. With
+// namesOnly it prints just the target names, one per line, for scripts and
+// shell completion.
+func listTargets(sel buildOptions, namesOnly bool) {
 	// Check for CMakeLists.txt
 	if _, err := os.Stat("CMakeLists.txt"); os.IsNotExist(err) {
 		fmt.Println("Error: CMakeLists.txt not found in the current directory.")
@@ -102,211 +308,119 @@ func listTargets() {
 		return
 	}
 
-	// Read CMakeLists.txt
-	cmakelists, err := os.ReadFile("CMakeLists.txt")
+	// Read the project, including sub-projects
+	model, err := loadProject()
 	if err != nil {
 		fmt.Printf("Error reading CMakeLists.txt: %s\n", err)
 		return
 	}
 
-	cmakeContent := string(cmakelists)
-
-	// Find executable targets
-	exeRegex := regexp.MustCompile(`add_executable\(([^):\n\s]+)`)
-	exeMatches := exeRegex.FindAllStringSubmatch(cmakeContent, -1)
-
-	// Find library targets
-	libRegex := regexp.MustCompile(`add_library\(([^):\n\s]+)`)
-	libMatches := libRegex.FindAllStringSubmatch(cmakeContent, -1)
+	if namesOnly {
+		for _, name := range model.targetNames() {
+			fmt.Println(name)
+		}
+		return
+	}
 
-	if len(exeMatches) == 0 && len(libMatches) == 0 {
+	if len(model.Targets) == 0 {
 		fmt.Println("No targets found in CMakeLists.txt.")
 		return
 	}
 
 	fmt.Println("Project targets:")
 
-	if len(exeMatches) > 0 {
-		fmt.Println("\nExecutables:")
-		for i, match := range exeMatches {
-			if len(match) > 1 {
-				fmt.Printf("  %d. %s\n", i+1, match[1])
+	for _, group := range []struct {
+		Kind  string
+		Title string
+	}{
+		{kindExecutable, "Executables"},
+		{kindLibrary, "Libraries"},
+		{kindCustom, "Custom targets"},
+	} {
+		i := 0
+		for _, target := range model.Targets {
+			if target.Kind != group.Kind {
+				continue
 			}
-		}
-	}
-
-	if len(libMatches) > 0 {
-		fmt.Println("\nLibraries:")
-		for i, match := range libMatches {
-			if len(match) > 1 {
-				fmt.Printf("  %d. %s\n", i+1, match[1])
+			if i == 0 {
+				fmt.Printf("\n%s:\n", group.Title)
+			}
+			i++
+			if target.Dir == "." {
+				fmt.Printf("  %d. %s\n", i, target.Name)
+			} else {
+				fmt.Printf("  %d. %s (%s)\n", i, target.Name, filepath.ToSlash(target.Dir))
 			}
 		}
 	}
 
 	// Also check if the project has been built and look for actual executables
-	if _, err := os.Stat("build"); !os.IsNotExist(err) {
-		// Get current directory to construct build path
-		cwd, err := os.Getwd()
-		if err == nil {
-			// Check if bin directory exists (standard layout)
-			binDir := cwd + "/build/bin"
-			executablesPath := binDir
-			if _, err := os.Stat(binDir); os.IsNotExist(err) {
-				// Fall back to just the build directory
-				executablesPath = cwd + "/build"
-			}
-
-			// Try to find executables in the build directory
-			files, err := os.ReadDir(executablesPath)
-			if err == nil {
-				// Look for executable files
-				var executables []string
-				for _, file := range files {
-					// Skip directories and files starting with "."
-					if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
-						continue
-					}
-
-					// Check if file is executable
-					filePath := filepath.Join(executablesPath, file.Name())
-					fileInfo, err := os.Stat(filePath)
-					if err != nil {
-						continue
-					}
-
-					// Check if file has execute permission
-					if fileInfo.Mode()&0111 != 0 {
-						executables = append(executables, file.Name())
-					}
-				}
-
-				if len(executables) > 0 {
-					fmt.Println("\nBuilt executables:")
-					for i, exe := range executables {
-						fmt.Printf("  %d. %s\n", i+1, exe)
-					}
-				}
-			}
+	buildDir, err := selectBuildDir(sel)
+	if err != nil {
+		if sel.Config != "" || sel.selectsVariant() {
+			fmt.Printf("\nNote: %s\n", err)
 		}
-	}
-}
-
-// buildProject creates a build directory, runs cmake and make
-func buildProject() {
-	// Check for CMakeLists.txt
-	if _, err := os.Stat("CMakeLists.txt"); os.IsNotExist(err) {
-		fmt.Println("Error: CMakeLists.txt not found in the current directory.")
-		fmt.Println("Run 'qs init' to create a new CMake project.")
 		return
 	}
 
-	// Create build directory if it doesn't exist
-	if _, err := os.Stat("build"); os.IsNotExist(err) {
-		fmt.Println("Creating build directory...")
-		err := os.Mkdir("build", 0755)
-		if err != nil {
-			fmt.Printf("Error creating build directory: %s\n", err)
-			return
+	executables := findExecutables(executablesDir(buildDir))
+	if len(executables) > 0 {
+		fmt.Printf("\nBuilt executables (%s):\n", buildDir)
+		for i, exe := range executables {
+			fmt.Printf("  %d. %s\n", i+1, exe)
 		}
 	}
+}
 
-	// Change to build directory
-	cwd, err := os.Getwd()
+// runProject runs a built executable target from the selected (or most
+// recently built) build directory
+func runProject(targetName string, sel buildOptions) {
+	buildDir, err := selectBuildDir(sel)
 	if err != nil {
-		fmt.Printf("Error getting current directory: %s\n", err)
+		fmt.Printf("Error: %s\n", err)
 		return
 	}
 
-	buildDir := cwd + "/build"
-
-	// Run cmake
-	fmt.Println("Running CMake...")
-	cmakeCmd := exec.Command("cmake", "..")
-	cmakeCmd.Dir = buildDir
-	cmakeCmd.Stdout = os.Stdout
-	cmakeCmd.Stderr = os.Stderr
-
-	err = cmakeCmd.Run()
-	if err != nil {
-		fmt.Printf("Error running cmake: %s\n", err)
+	targetPath, ok := findRunTarget(targetName, buildDir)
+	if !ok {
 		return
 	}
 
-	// Run make
-	fmt.Println("Running make...")
-	makeCmd := exec.Command("make")
-	makeCmd.Dir = buildDir
-	makeCmd.Stdout = os.Stdout
-	makeCmd.Stderr = os.Stderr
+	// Run the executable
+	fmt.Printf("Running %s...\n", filepath.Base(targetPath))
+	cmd := exec.Command(targetPath)
+	cmd.Env = sanitizerEnv(buildDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
 
-	err = makeCmd.Run()
+	err = cmd.Run()
 	if err != nil {
-		fmt.Printf("Error running make: %s\n", err)
+		fmt.Printf("Error running target: %s\n", err)
 		return
 	}
-
-	fmt.Println("Build completed successfully!")
 }
 
-// runProject runs a built executable target from the build directory
-func runProject(targetName string) {
-	// Check for build directory
-	if _, err := os.Stat("build"); os.IsNotExist(err) {
-		fmt.Println("Error: build directory not found.")
-		fmt.Println("Run 'qs build' to build the project first.")
-		return
-	}
-
-	// Get current directory to construct build path
-	cwd, err := os.Getwd()
-	if err != nil {
-		fmt.Printf("Error getting current directory: %s\n", err)
-		return
-	}
-
-	// Check if bin directory exists (standard layout)
-	binDir := cwd + "/build/bin"
-	executablesPath := binDir
-	if _, err := os.Stat(binDir); os.IsNotExist(err) {
-		// Fall back to just the build directory
-		executablesPath = cwd + "/build"
-	}
+// findRunTarget returns the path of the executable to run from a build
+// directory: the named target, or the only executable there is
+func findRunTarget(targetName string, buildDir string) (string, bool) {
+	executablesPath := executablesDir(buildDir)
 
 	// If no target specified, try to find one
 	if targetName == "" {
 		// Try to find an executable in the build directory
-		files, err := os.ReadDir(executablesPath)
-		if err != nil {
+		if _, err := os.ReadDir(executablesPath); err != nil {
 			fmt.Printf("Error reading build directory: %s\n", err)
-			return
+			return "", false
 		}
 
 		// Look for executable files
-		var executables []string
-		for _, file := range files {
-			// Skip directories and files starting with "."
-			if file.IsDir() || strings.HasPrefix(file.Name(), ".") {
-				continue
-			}
-
-			// On Unix systems, check if file is executable
-			filePath := filepath.Join(executablesPath, file.Name())
-			fileInfo, err := os.Stat(filePath)
-			if err != nil {
-				continue
-			}
-
-			// Check if file has execute permission
-			if fileInfo.Mode()&0111 != 0 {
-				executables = append(executables, file.Name())
-			}
-		}
+		executables := findExecutables(executablesPath)
 
 		if len(executables) == 0 {
 			fmt.Println("Error: No executable targets found in build directory.")
 			fmt.Println("Specify a target name or build the project first with 'qs build'.")
-			return
+			return "", false
 		} else if len(executables) == 1 {
 			targetName = executables[0]
 			fmt.Printf("Running target: %s\n", targetName)
@@ -317,29 +431,17 @@ func runProject(targetName string) {
 				fmt.Printf("  %d. %s\n", i+1, exe)
 			}
 			fmt.Println("Please specify a target name: qs run <target>")
-			return
+			return "", false
 		}
 	}
 
 	// Construct path to the executable
 	targetPath := filepath.Join(executablesPath, targetName)
 	if _, err := os.Stat(targetPath); os.IsNotExist(err) {
-		fmt.Printf("Error: Target '%s' not found in build directory.\n", targetName)
-		return
-	}
-
-	// Run the executable
-	fmt.Printf("Running %s...\n", targetName)
-	cmd := exec.Command(targetPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Stdin = os.Stdin
-
-	err = cmd.Run()
-	if err != nil {
-		fmt.Printf("Error running target: %s\n", err)
-		return
+		fmt.Printf("Error: Target '%s' not found in %s.\n", targetName, buildDir)
+		return "", false
 	}
+	return targetPath, true
 }
 
 // openDocumentation opens the CMake documentation in the default browser
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// matrixLogFile is where a matrix build keeps the output of each build
+const matrixLogFile = "qs-build.log"
+
+// matrixEntryEnv is set for the qs processes of a matrix build. They leave
+// recording the active build directory and linking compile_commands.json to
+// the matrix, which does it once all builds are done.
+const matrixEntryEnv = "QS_MATRIX_ENTRY"
+
+// matrixWarningLimit is how many merged warnings a matrix build lists
+const matrixWarningLimit = 20
+
+// matrixResult is the outcome of one compiler of a matrix build
+type matrixResult struct {
+	Compiler    compilerChoice
+	Version     string // compiler identification, e.g. "GNU 12.2.0"
+	Dir         string // build directory, relative to the project root
+	OK          bool
+	Elapsed     time.Duration
+	Diagnostics *diagnosticCollector
+}
+
+// parseMatrix resolves the comma separated compilers of --matrix
+func parseMatrix(list string) ([]compilerChoice, error) {
+	var matrix []compilerChoice
+	names := map[string]string{}
+	for _, spec := range strings.Split(list, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		compiler, err := resolveCompiler(spec)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := names[compiler.Name]; ok {
+			return nil, fmt.Errorf("'%s' and '%s' would share the build directory of %s, list each compiler once", other, spec, compiler.Name)
+		}
+		names[compiler.Name] = spec
+		matrix = append(matrix, compiler)
+	}
+	if len(matrix) == 0 {
+		return nil, fmt.Errorf("'--matrix' requires at least one compiler, e.g. gcc,clang")
+	}
+	return matrix, nil
+}
+
+// matrixArgs returns the qs arguments that build one compiler of a matrix
+func matrixArgs(opts buildOptions, compiler compilerChoice, jobs int) []string {
+	command := "build"
+	if opts.Clean {
+		command = "rebuild"
+	}
+	args := []string{command, "--config", opts.Config, "--compiler", compiler.Spec, "-j", strconv.Itoa(jobs)}
+	if opts.Generator != "" {
+		args = append(args, "-G", opts.Generator)
+	}
+	if opts.Reconfigure {
+		args = append(args, "--reconfigure")
+	}
+	for _, entry := range opts.CacheVars {
+		args = append(args, "-D"+entry.String())
+	}
+	if opts.Diagnostics != "" {
+		args = append(args, "--diagnostics", opts.Diagnostics)
+	}
+	if len(opts.Sanitizers) > 0 {
+		args = append(args, "--sanitize", strings.Join(opts.Sanitizers, ","))
+	}
+	if opts.Timings {
+		args = append(args, "--timings")
+	}
+	return append(args, opts.Targets...)
+}
+
+// buildMatrix builds the project with each compiler of opts.Matrix in its own
+// build directory. The builds run side by side in separate qs processes, as
+// many at a time as there are CPUs, sharing the CPUs between them. Each
+// build's output goes to a log in its build directory; a table of results and
+// the diagnostics of all compilers merged follow at the end.
+func buildMatrix(opts buildOptions) bool {
+	if _, err := os.Stat("CMakeLists.txt"); os.IsNotExist(err) {
+		fmt.Println("Error: CMakeLists.txt not found in the current directory.")
+		fmt.Println("Run 'qs init' to create a new CMake project.")
+		return false
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		fmt.Printf("Error: cannot find the qs executable: %s\n", err)
+		return false
+	}
+
+	workers := len(opts.Matrix)
+	if workers > runtime.NumCPU() {
+		workers = runtime.NumCPU()
+	}
+	jobs := opts.Jobs
+	if jobs == 0 {
+		jobs = runtime.NumCPU() / workers
+		if jobs < 1 {
+			jobs = 1
+		}
+	}
+	var names []string
+	for _, compiler := range opts.Matrix {
+		names = append(names, compiler.Name)
+	}
+	fmt.Printf("Building with %s (%d at a time, %d jobs each)...\n", strings.Join(names, ", "), workers, jobs)
+
+	results := make([]matrixResult, len(opts.Matrix))
+	queue := make(chan int)
+	var printing sync.Mutex
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				results[i] = buildMatrixEntry(executable, opts, opts.Matrix[i], jobs)
+				printing.Lock()
+				printMatrixProgress(results[i])
+				printing.Unlock()
+			}
+		}()
+	}
+	for i := range opts.Matrix {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	// The first build that passed becomes the active one
+	active := results[0]
+	for _, result := range results {
+		if result.OK {
+			active = result
+			break
+		}
+	}
+	if fileExists(filepath.Join(active.Dir, "CMakeCache.txt")) {
+		setActiveBuildDir(active.Dir)
+		if abs, err := filepath.Abs(active.Dir); err == nil {
+			linkCompileCommands(abs)
+		}
+	}
+
+	printMatrixTable(results)
+	printMergedDiagnostics(results)
+
+	for _, result := range results {
+		if !result.OK {
+			return false
+		}
+	}
+	fmt.Println("All builds completed successfully!")
+	return true
+}
+
+// buildMatrixEntry runs 'qs build --compiler' for one compiler of a matrix,
+// collecting the diagnostics from its output
+func buildMatrixEntry(executable string, opts buildOptions, compiler compilerChoice, jobs int) matrixResult {
+	entryOpts := opts
+	entryOpts.Matrix = nil
+	entryOpts.Compiler = compiler
+	result := matrixResult{
+		Compiler: compiler,
+		Dir:      entryOpts.Dir(),
+	}
+	info := identifyCompiler([]string{compiler.CXX})
+	result.Version = strings.TrimSpace(info.ID + " " + info.Version)
+
+	buildDir, _ := filepath.Abs(result.Dir)
+	result.Diagnostics = newDiagnosticCollector(buildDir)
+	if err := os.MkdirAll(buildDir, 0755); err != nil {
+		fmt.Printf("Error creating build directory: %s\n", err)
+		return result
+	}
+	log, err := os.Create(filepath.Join(buildDir, matrixLogFile))
+	if err != nil {
+		fmt.Printf("Error creating build log: %s\n", err)
+		return result
+	}
+	defer log.Close()
+
+	start := time.Now()
+	cmd := exec.Command(executable, matrixArgs(opts, compiler, jobs)...)
+	output := io.MultiWriter(log, result.Diagnostics)
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.Env = append(os.Environ(), matrixEntryEnv+"=1")
+	result.OK = cmd.Run() == nil
+	result.Elapsed = time.Since(start)
+	return result
+}
+
+// printMatrixProgress reports a finished build of a matrix
+func printMatrixProgress(result matrixResult) {
+	status := "passed"
+	if !result.OK {
+		status = "FAILED"
+	}
+	fmt.Printf("  %s %s in %s\n", result.Compiler.Name, status, seconds(result.Elapsed.Microseconds()))
+}
+
+// printMatrixTable prints the results of a matrix build side by side
+func printMatrixTable(results []matrixResult) {
+	fmt.Println()
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "Compiler\tVersion\tResult\tTime\tErrors\tWarnings\tBuild directory")
+	for _, result := range results {
+		status := "passed"
+		if !result.OK {
+			status = "FAILED"
+		}
+		errors, warnings := result.Diagnostics.counts()
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%d\t%d\t%s\n", result.Compiler.Name, result.Version, status,
+			seconds(result.Elapsed.Microseconds()), errors, warnings, filepath.ToSlash(result.Dir))
+	}
+	table.Flush()
+
+	for _, result := range results {
+		if !result.OK {
+			fmt.Printf("Build log of %s: %s\n", result.Compiler.Name, filepath.ToSlash(filepath.Join(result.Dir, matrixLogFile)))
+		}
+	}
+}
+
+// mergedDiagnostic is a diagnostic of a matrix build with the compilers that
+// reported it
+type mergedDiagnostic struct {
+	diagnostic
+	Compilers []string
+}
+
+// mergeDiagnostics combines the diagnostics of a matrix build. GCC and Clang
+// word their messages differently, so diagnostics are the same when they
+// have the same line, severity and warning option.
+func mergeDiagnostics(results []matrixResult) []*mergedDiagnostic {
+	var merged []*mergedDiagnostic
+	byKey := map[string]*mergedDiagnostic{}
+	for _, result := range results {
+		for _, d := range result.Diagnostics.diagnostics {
+			key := fmt.Sprintf("%s:%d:%s:", d.File, d.Line, d.Severity)
+			if d.Flag != "" {
+				key += d.Flag
+			} else {
+				key += d.Message
+			}
+			if m, ok := byKey[key]; ok {
+				if !containsWord(m.Compilers, result.Compiler.Name) {
+					m.Compilers = append(m.Compilers, result.Compiler.Name)
+				}
+				continue
+			}
+			m := &mergedDiagnostic{diagnostic: d, Compilers: []string{result.Compiler.Name}}
+			byKey[key] = m
+			merged = append(merged, m)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		if merged[i].File != merged[j].File {
+			return merged[i].File < merged[j].File
+		}
+		return merged[i].Line < merged[j].Line
+	})
+	return merged
+}
+
+// printMergedDiagnostics prints the errors and warnings of all compilers of a
+// matrix build, each once with the compilers that reported it
+func printMergedDiagnostics(results []matrixResult) {
+	merged := mergeDiagnostics(results)
+	errors, warnings := 0, 0
+	for _, m := range merged {
+		if m.Severity == "error" {
+			errors++
+		} else {
+			warnings++
+		}
+	}
+	if errors == 0 && warnings == 0 {
+		return
+	}
+	fmt.Printf("\nDiagnostics of all compilers: %s, %s\n", plural(errors, "error"), plural(warnings, "warning"))
+
+	show := func(m *mergedDiagnostic) {
+		message := m.Message
+		if m.Flag != "" {
+			message += " [" + m.Flag + "]"
+		}
+		compilers := "all"
+		if len(m.Compilers) < len(results) {
+			compilers = strings.Join(m.Compilers, ", ")
+		}
+		fmt.Printf("  %s: %s (%s)\n", m.location(), message, compilers)
+	}
+	if errors > 0 {
+		fmt.Println("Errors:")
+		for _, m := range merged {
+			if m.Severity == "error" {
+				show(m)
+			}
+		}
+	}
+	if warnings > 0 {
+		fmt.Println("Warnings:")
+		listed := 0
+		for _, m := range merged {
+			if m.Severity != "warning" {
+				continue
+			}
+			if listed == matrixWarningLimit {
+				fmt.Printf("  ... and %d more, see the build logs\n", warnings-listed)
+				break
+			}
+			show(m)
+			listed++
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestMatrixEntryKeepsSelectedProfile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake compilers are shell scripts")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"gcc", "g++"} {
+		script := "#!/bin/sh\necho '" + name + " (GCC) 12.2.0'\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	saved := settings
+	defer func() { settings = saved }()
+	settings = qsSettings{Profiles: map[string]*buildProfile{
+		"default": {Config: "Debug", Sanitize: []string{"address"}, Define: []string{"FROM_DEFAULT=ON"}},
+		"fast":    {Config: "Release", Define: []string{"FROM_FAST=ON"}},
+	}}
+	settings.Build.Profile = "default"
+
+	parent, err := parseBuildArgs([]string{"--profile", "fast", "--matrix", "gcc"})
+	if err != nil {
+		t.Fatalf("parseBuildArgs: %s", err)
+	}
+	if len(parent.Matrix) != 1 {
+		t.Fatalf("matrix has %d entries, want 1", len(parent.Matrix))
+	}
+
+	t.Setenv(matrixEntryEnv, "1")
+	args := matrixArgs(parent, parent.Matrix[0], 1)
+	child, err := parseBuildArgs(args[1:])
+	if err != nil {
+		t.Fatalf("parseBuildArgs(%q): %s", args[1:], err)
+	}
+	if child.Config != "Release" {
+		t.Errorf("config %q, want Release", child.Config)
+	}
+	if len(child.Sanitizers) != 0 {
+		t.Errorf("sanitizers %q from the default profile, want none", child.Sanitizers)
+	}
+	want := []cacheEntry{{Name: "FROM_FAST", Value: "ON"}}
+	if !reflect.DeepEqual(child.CacheVars, want) {
+		t.Errorf("cache options %v, want %v", child.CacheVars, want)
+	}
+	if child.Compiler.Name != "gcc" {
+		t.Errorf("compiler %q, want gcc", child.Compiler.Name)
+	}
+}
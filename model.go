@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Target kinds
+const (
+	kindExecutable = "executable"
+	kindLibrary    = "library"
+	kindCustom     = "custom"
+)
+
+// cmakeTarget is a target defined with add_executable, add_library or add_custom_target
+type cmakeTarget struct {
+	Name string
+	Kind string // kindExecutable, kindLibrary or kindCustom
+	Type string // library type: STATIC, SHARED, MODULE, INTERFACE, OBJECT or empty for the default
+	Dir  string // directory of the CMakeLists.txt defining the target, relative to the project root
+}
+
+// projectModel is what qs knows about a project after reading its CMake files
+type projectModel struct {
+	Name        string
+	Version     string
+	Description string
+	Targets     []cmakeTarget
+	Dirs        []string // directories with a CMakeLists.txt reachable through add_subdirectory
+}
+
+var (
+	commentRegex      = regexp.MustCompile(`(?m)^[ \t]*#.*$`)
+	projectRegex      = regexp.MustCompile(`project\(\s*([^)\s]+)([^)]*)\)`)
+	versionRegex      = regexp.MustCompile(`VERSION\s+([0-9][0-9.]*)`)
+	descriptionRegex  = regexp.MustCompile(`DESCRIPTION\s+"([^"]*)"`)
+	targetRegex       = regexp.MustCompile(`add_(executable|library|custom_target)\(\s*([^)\s]+)([^)]*)\)`)
+	subdirectoryRegex = regexp.MustCompile(`add_subdirectory\(\s*([^)\s]+)`)
+)
+
+// loadProject reads the CMakeLists.txt in the current directory and every
+// directory it includes with add_subdirectory
+func loadProject() (projectModel, error) {
+	var model projectModel
+
+	if !fileExists("CMakeLists.txt") {
+		return model, fmt.Errorf("CMakeLists.txt not found in the current directory")
+	}
+
+	queue := []string{"."}
+	seen := map[string]bool{}
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		data, err := os.ReadFile(filepath.Join(dir, "CMakeLists.txt"))
+		if err != nil {
+			if dir == "." {
+				return model, err
+			}
+			continue
+		}
+		model.Dirs = append(model.Dirs, dir)
+		content := stripCMakeComments(string(data))
+
+		if dir == "." {
+			if match := projectRegex.FindStringSubmatch(content); match != nil {
+				model.Name = match[1]
+				if version := versionRegex.FindStringSubmatch(match[2]); version != nil {
+					model.Version = version[1]
+				}
+				if description := descriptionRegex.FindStringSubmatch(match[2]); description != nil {
+					model.Description = description[1]
+				}
+			}
+		}
+
+		for _, match := range targetRegex.FindAllStringSubmatch(content, -1) {
+			args := strings.Fields(match[3])
+			target := cmakeTarget{Name: match[2], Kind: match[1], Dir: dir}
+			if target.Kind == "custom_target" {
+				target.Kind = kindCustom
+			}
+			if containsWord(args, "IMPORTED") || containsWord(args, "ALIAS") {
+				continue
+			}
+			if target.Kind == kindLibrary {
+				for _, libraryType := range []string{"STATIC", "SHARED", "MODULE", "INTERFACE", "OBJECT"} {
+					if containsWord(args, libraryType) {
+						target.Type = libraryType
+					}
+				}
+			}
+			model.Targets = append(model.Targets, target)
+		}
+
+		for _, match := range subdirectoryRegex.FindAllStringSubmatch(content, -1) {
+			queue = append(queue, filepath.Join(dir, match[1]))
+		}
+	}
+
+	if model.Name == "" {
+		model.Name = getProjectName()
+	}
+	return model, nil
+}
+
+// findTarget looks up a target by name
+func (p projectModel) findTarget(name string) (cmakeTarget, bool) {
+	for _, target := range p.Targets {
+		if target.Name == name {
+			return target, true
+		}
+	}
+	return cmakeTarget{}, false
+}
+
+// targetNames returns the names of all targets, sorted
+func (p projectModel) targetNames() []string {
+	names := []string{}
+	for _, target := range p.Targets {
+		names = append(names, target.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// stripCMakeComments removes whole-line comments so commented-out commands
+// are not mistaken for real ones
+func stripCMakeComments(content string) string {
+	return commentRegex.ReplaceAllString(content, "")
+}
+
+// cmakeCommentStart returns the index of the '#' that starts a comment in a
+// line of CMake code, or -1 if there is none. A '#' in a quoted argument
+// does not start a comment.
+func cmakeCommentStart(line string) int {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func containsWord(words []string, word string) bool {
+	for _, w := range words {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinTargets are targets CMake generates for every project
+var builtinTargets = []string{"all", "clean", "install", "test", "package", "package_source"}
+
+// suggestTargets returns the names closest to a misspelled target name
+func suggestTargets(name string, names []string) []string {
+	var suggestions []string
+	best := -1
+	limit := len(name)/3 + 1
+	for _, candidate := range names {
+		distance := editDistance(strings.ToLower(name), strings.ToLower(candidate))
+		if strings.HasPrefix(strings.ToLower(candidate), strings.ToLower(name)) {
+			distance = 0
+		}
+		if distance > limit {
+			continue
+		}
+		if best == -1 || distance < best {
+			best = distance
+			suggestions = []string{candidate}
+		} else if distance == best {
+			suggestions = append(suggestions, candidate)
+		}
+	}
+	return suggestions
+}
+
+// editDistance computes the Levenshtein distance between two strings
+func editDistance(a string, b string) int {
+	previous := make([]int, len(b)+1)
+	current := make([]int, len(b)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		current[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			current[j] = previous[j] + 1
+			if current[j-1]+1 < current[j] {
+				current[j] = current[j-1] + 1
+			}
+			if previous[j-1]+cost < current[j] {
+				current[j] = previous[j-1] + cost
+			}
+		}
+		previous, current = current, previous
+	}
+	return previous[len(b)]
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// cpackSection is the name of the managed section holding the CPack configuration
+const cpackSection = "cpack"
+
+// packageFormats maps 'qs package --format' values to CPack generators
+var packageFormats = map[string]string{
+	"tgz": "TGZ",
+	"zip": "ZIP",
+	"deb": "DEB",
+	"rpm": "RPM",
+}
+
+// packageOptions holds the arguments of 'qs package'
+type packageOptions struct {
+	Format  string // key of packageFormats
+	Vendor  string
+	Contact string
+	Config  string // build configuration to package
+}
+
+var (
+	cpackVendorRegex   = regexp.MustCompile(`set\(CPACK_PACKAGE_VENDOR "((?:[^"\\]|\\.)*)"\)`)
+	cpackContactRegex  = regexp.MustCompile(`set\(CPACK_PACKAGE_CONTACT "((?:[^"\\]|\\.)*)"\)`)
+	cmakeEscapeRegex   = regexp.MustCompile(`\\(.)`)
+	cpackGeneratedLine = regexp.MustCompile(`CPack: - package: (.+) generated\.`)
+)
+
+// parsePackageArgs parses the arguments following 'qs package'
+func parsePackageArgs(args []string) (packageOptions, error) {
+	opts := packageOptions{Format: "tgz", Config: "Release", Vendor: settings.Package.Vendor, Contact: settings.Package.Contact}
+	if settings.Package.Format != "" {
+		opts.Format = settings.Package.Format
+	}
+
+	config, args, err := extractConfig(args)
+	if err != nil {
+		return opts, err
+	}
+	if config != "" {
+		opts.Config = config
+	}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			parts := strings.SplitN(arg, "=", 2)
+			arg, value = parts[0], parts[1]
+		}
+
+		switch arg {
+		case "--format", "--vendor", "--contact":
+			if value == "" {
+				if i+1 >= len(args) {
+					return opts, fmt.Errorf("'%s' requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			switch arg {
+			case "--format":
+				value = strings.ToLower(value)
+				if _, ok := packageFormats[value]; !ok {
+					return opts, fmt.Errorf("unknown package format '%s' (expected tgz, zip, deb or rpm)", value)
+				}
+				opts.Format = value
+			case "--vendor":
+				opts.Vendor = value
+			case "--contact":
+				opts.Contact = value
+			}
+		default:
+			return opts, fmt.Errorf("unknown option '%s'", arg)
+		}
+	}
+
+	return opts, nil
+}
+
+// cmakeEscape escapes a value for a quoted CMake argument, so quotes,
+// backslashes and ${...} in it are taken literally
+func cmakeEscape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(value)
+}
+
+// cmakeUnescape reverses cmakeEscape
+func cmakeUnescape(value string) string {
+	return cmakeEscapeRegex.ReplaceAllString(value, "$1")
+}
+
+// renderCPackConfig generates the body of the managed CPack section. Name,
+// version and description come from the project() call at configure time.
+func renderCPackConfig(vendor string, contact string) string {
+	return fmt.Sprintf(`set(CPACK_PACKAGE_NAME "${PROJECT_NAME}")
+if(PROJECT_VERSION)
+    set(CPACK_PACKAGE_VERSION "${PROJECT_VERSION}")
+endif()
+if(PROJECT_DESCRIPTION)
+    set(CPACK_PACKAGE_DESCRIPTION_SUMMARY "${PROJECT_DESCRIPTION}")
+endif()
+if(PROJECT_HOMEPAGE_URL)
+    set(CPACK_PACKAGE_HOMEPAGE_URL "${PROJECT_HOMEPAGE_URL}")
+endif()
+set(CPACK_PACKAGE_VENDOR "%s")
+set(CPACK_PACKAGE_CONTACT "%s")
+set(CPACK_GENERATOR "TGZ")
+set(CPACK_PACKAGE_DIRECTORY "${CMAKE_BINARY_DIR}/packages")
+
+# Use the distribution's naming conventions for .deb and .rpm files
+set(CPACK_DEBIAN_FILE_NAME DEB-DEFAULT)
+set(CPACK_RPM_FILE_NAME RPM-DEFAULT)
+find_program(QS_DPKG_SHLIBDEPS dpkg-shlibdeps)
+if(QS_DPKG_SHLIBDEPS)
+    set(CPACK_DEBIAN_PACKAGE_SHLIBDEPS ON)
+endif()
+
+include(CPack)
+`, cmakeEscape(vendor), cmakeEscape(contact))
+}
+
+// gitConfigValue returns a value from the user's git configuration, if any
+func gitConfigValue(key string) string {
+	output, err := exec.Command("git", "config", "--get", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// updateCPackConfig inserts or refreshes the managed CPack section, keeping
+// the vendor and contact of an existing section unless new ones are given
+func updateCPackConfig(opts packageOptions) (projectModel, bool) {
+	model, err := loadProject()
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		fmt.Println("Run 'qs init' to create a new CMake project.")
+		return model, false
+	}
+
+	cmakelists, err := os.ReadFile("CMakeLists.txt")
+	if err != nil {
+		fmt.Printf("Error reading CMakeLists.txt: %v\n", err)
+		return model, false
+	}
+	content := string(cmakelists)
+
+	vendor := opts.Vendor
+	if match := cpackVendorRegex.FindStringSubmatch(content); vendor == "" && match != nil {
+		vendor = cmakeUnescape(match[1])
+	}
+	if vendor == "" {
+		vendor = model.Name
+	}
+
+	contact := opts.Contact
+	if match := cpackContactRegex.FindStringSubmatch(content); contact == "" && match != nil {
+		contact = cmakeUnescape(match[1])
+	}
+	if contact == "" {
+		name, email := gitConfigValue("user.name"), gitConfigValue("user.email")
+		switch {
+		case name != "" && email != "":
+			contact = fmt.Sprintf("%s <%s>", name, email)
+		case email != "":
+			contact = email
+		default:
+			contact = vendor
+		}
+	}
+
+	updated := setManagedSection(content, cpackSection, renderCPackConfig(vendor, contact))
+	if hasManagedSection(updated, installRulesSection) {
+		// Keep the install rules last, after every target definition
+		updated = setInstallSection(updated, managedSectionBody(updated, installRulesSection))
+	}
+	if updated != content {
+		err = os.WriteFile("CMakeLists.txt", []byte(updated), 0644)
+		if err != nil {
+			fmt.Printf("Error updating CMakeLists.txt: %v\n", err)
+			return model, false
+		}
+		fmt.Println("Updated CPack configuration in CMakeLists.txt")
+	}
+
+	if model.Version == "" {
+		fmt.Printf("Note: project(%s) has no VERSION, CPack will use its default version.\n", model.Name)
+		fmt.Printf("Add one with e.g. project(%s VERSION 1.0.0).\n", model.Name)
+	}
+	if !hasInstallRules() {
+		fmt.Println("Note: Packages contain what the install rules install, run 'qs install-rules' to cover all targets.")
+	}
+	return model, true
+}
+
+// packageProject builds the project and runs cpack to create packages
+func packageProject(opts packageOptions) bool {
+	generator := packageFormats[opts.Format]
+	if generator == "RPM" {
+		if _, err := exec.LookPath("rpmbuild"); err != nil {
+			fmt.Println("Error: Building RPM packages requires rpmbuild.")
+			fmt.Println("Install it with your package manager (e.g. 'dnf install rpm-build' or 'apt install rpm').")
+			return false
+		}
+	}
+
+	if _, ok := updateCPackConfig(opts); !ok {
+		return false
+	}
+
+	build := buildOptions{Config: opts.Config}
+	if !buildProject(build) {
+		return false
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		fmt.Printf("Error getting current directory: %s\n", err)
+		return false
+	}
+	buildDir := filepath.Join(cwd, build.Dir())
+
+	fmt.Printf("Running CPack (%s)...\n", generator)
+	var output bytes.Buffer
+	cmd := exec.Command("cpack", "-G", generator, "-C", opts.Config)
+	cmd.Dir = buildDir
+	cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+	cmd.Stderr = os.Stderr
+	err = cmd.Run()
+	if err != nil {
+		fmt.Printf("Error running cpack: %s\n", err)
+		return false
+	}
+
+	matches := cpackGeneratedLine.FindAllStringSubmatch(output.String(), -1)
+	if len(matches) == 0 {
+		fmt.Println("CPack finished but reported no packages.")
+		return false
+	}
+	fmt.Println("\nGenerated packages:")
+	for _, match := range matches {
+		path := match[1]
+		if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+		fmt.Printf("  %s\n", path)
+	}
+	return true
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sanitizer describes one of the sanitizers of GCC and Clang
+type sanitizer struct {
+	Name     string   // -fsanitize= name
+	Short    string   // used in build directory names
+	Flags    []string // compile flags besides -fsanitize=
+	EnvVar   string   // runtime options variable
+	Defaults string   // default runtime options
+}
+
+// sanitizers lists the supported sanitizers, in build directory name order
+var sanitizers = []sanitizer{
+	{"address", "asan", nil, "ASAN_OPTIONS", "symbolize=1:detect_stack_use_after_return=1:strict_string_checks=1"},
+	{"undefined", "ubsan", nil, "UBSAN_OPTIONS", "symbolize=1:print_stacktrace=1:halt_on_error=1"},
+	{"thread", "tsan", nil, "TSAN_OPTIONS", "symbolize=1:second_deadlock_stack=1"},
+	{"memory", "msan", []string{"-fsanitize-memory-track-origins"}, "MSAN_OPTIONS", "symbolize=1"},
+}
+
+// incompatibleSanitizers lists the sanitizers that cannot be combined
+var incompatibleSanitizers = [][2]string{
+	{"address", "thread"},
+	{"address", "memory"},
+	{"thread", "memory"},
+}
+
+// lookupSanitizer finds a sanitizer by name or short name, e.g. "address" or "asan"
+func lookupSanitizer(name string) (sanitizer, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for _, s := range sanitizers {
+		if s.Name == name || s.Short == name {
+			return s, true
+		}
+	}
+	return sanitizer{}, false
+}
+
+// parseSanitizers parses a comma separated list of sanitizers and rejects
+// unknown names and combinations the compilers refuse. The result is in
+// the order of the sanitizers table.
+func parseSanitizers(list string) ([]string, error) {
+	selected := map[string]bool{}
+	for _, name := range strings.Split(list, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		s, ok := lookupSanitizer(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown sanitizer '%s' (expected address, undefined, thread or memory)", name)
+		}
+		selected[s.Name] = true
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("'--sanitize' requires at least one sanitizer")
+	}
+
+	for _, pair := range incompatibleSanitizers {
+		if selected[pair[0]] && selected[pair[1]] {
+			return nil, fmt.Errorf("the %s and %s sanitizers cannot be combined, build them separately", pair[0], pair[1])
+		}
+	}
+
+	var names []string
+	for _, s := range sanitizers {
+		if selected[s.Name] {
+			names = append(names, s.Name)
+		}
+	}
+	return names, nil
+}
+
+// sanitizerDirSuffix returns the build directory suffix for a set of
+// sanitizers, e.g. "asan-ubsan"
+func sanitizerDirSuffix(names []string) string {
+	var shorts []string
+	for _, name := range names {
+		s, _ := lookupSanitizer(name)
+		shorts = append(shorts, s.Short)
+	}
+	return strings.Join(shorts, "-")
+}
+
+// checkSanitizerSupport reports an error if the compiler cannot build with
+// the given sanitizers
+func checkSanitizerSupport(compiler compilerInfo, names []string) error {
+	for _, name := range names {
+		if name == "memory" && compiler.ID != "" && compiler.ID != "Clang" {
+			return fmt.Errorf("the memory sanitizer needs Clang on Linux, %s does not support it", compiler)
+		}
+	}
+	return nil
+}
+
+// sanitizerFlags returns the compile and link flags for a set of sanitizers
+func sanitizerFlags(names []string) string {
+	flags := []string{"-fsanitize=" + strings.Join(names, ","), "-fno-omit-frame-pointer", "-g"}
+	for _, name := range names {
+		s, _ := lookupSanitizer(name)
+		flags = append(flags, s.Flags...)
+	}
+	return strings.Join(flags, " ")
+}
+
+// sanitizerEnv returns the environment for running programs of a build
+// directory: the sanitizer runtime options get symbolized defaults, which
+// options already in the environment override
+func sanitizerEnv(buildDir string) []string {
+	names := loadBuildState(buildDir).Sanitizers
+	if len(names) == 0 {
+		return nil
+	}
+
+	env := os.Environ()
+	for _, name := range names {
+		s, _ := lookupSanitizer(name)
+		value := s.Defaults
+		// Later options win, so the user's own settings come last
+		if current := os.Getenv(s.EnvVar); current != "" {
+			value += ":" + current
+		}
+		env = setEnv(env, s.EnvVar, value)
+	}
+
+	// The runtimes look for llvm-symbolizer under its plain name only, point
+	// them at a versioned one such as llvm-symbolizer-15 otherwise
+	if path := findSymbolizer(); path != "" {
+		for _, name := range []string{"ASAN_SYMBOLIZER_PATH", "MSAN_SYMBOLIZER_PATH"} {
+			if os.Getenv(name) == "" {
+				env = setEnv(env, name, path)
+			}
+		}
+	}
+	return env
+}
+
+// findSymbolizer returns the newest versioned llvm-symbolizer on PATH, e.g.
+// llvm-symbolizer-15, when there is no plain one, or an empty string
+func findSymbolizer() string {
+	if _, err := exec.LookPath("llvm-symbolizer"); err == nil {
+		return ""
+	}
+	best, bestVersion := "", 0
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, "llvm-symbolizer-*"))
+		for _, match := range matches {
+			version, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(match), "llvm-symbolizer-"))
+			if err == nil && version > bestVersion {
+				best, bestVersion = match, version
+			}
+		}
+	}
+	return best
+}
+
+// setEnv sets a variable in an environment list as used by exec.Cmd
+func setEnv(env []string, name string, value string) []string {
+	for i, entry := range env {
+		if strings.HasPrefix(entry, name+"=") {
+			env[i] = name + "=" + value
+			return env
+		}
+	}
+	return append(env, name+"="+value)
+}
@@ -0,0 +1,423 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// projectSettingsFile holds the project's qs settings, in the project root
+const projectSettingsFile = "qs.toml"
+
+// qsSettings are the defaults qs reads from the user's and the project's
+// settings files. Empty values keep the built-in defaults.
+type qsSettings struct {
+	Build struct {
+		Dir         string // directory holding the build directories
+		Generator   string // CMake generator for new build directories
+		Config      string // build type until another one is built
+		Jobs        int    // parallel build jobs
+		Launcher    string // compiler cache: auto, ccache, sccache or none
+		Diagnostics string // also write compiler diagnostics as json or sarif
+		Timings     bool   // report build timings
+		Profile     string // profile used when none is selected
+	}
+	Run struct {
+		Target string // executable qs run runs when none is given
+	}
+	Test struct {
+		Args []string // ctest arguments passed before the command line ones
+	}
+	Init struct {
+		CXXStandard string // C++ standard of new projects
+		Layout      string // header layout of new sub-projects
+	}
+	Install struct {
+		Prefix string
+	}
+	Package struct {
+		Format  string
+		Vendor  string
+		Contact string
+	}
+	Profiles map[string]*buildProfile
+
+	origins map[string]string // "file:line" each setting comes from, for errors
+}
+
+// buildProfile is a named set of build options, selected with --profile
+type buildProfile struct {
+	Compiler  string
+	Config    string
+	Toolchain string
+	Generator string
+	Sanitize  []string
+	Define    []string
+}
+
+// settings are the loaded settings, see loadSettings
+var settings = qsSettings{Profiles: map[string]*buildProfile{}}
+
+// settingsTables lists the tables of a settings file for error messages
+var settingsTables = []string{"build", "run", "test", "init", "install", "package", "profile.<name>"}
+
+// userSettingsFile returns the user's settings file, under
+// $XDG_CONFIG_HOME/qs or ~/.config/qs
+func userSettingsFile() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "qs", "config.toml")
+}
+
+// fields maps the keys of a settings table to the values they set
+func (s *qsSettings) fields(table string) (map[string]interface{}, error) {
+	switch table {
+	case "build":
+		return map[string]interface{}{
+			"dir":         &s.Build.Dir,
+			"generator":   &s.Build.Generator,
+			"config":      &s.Build.Config,
+			"jobs":        &s.Build.Jobs,
+			"launcher":    &s.Build.Launcher,
+			"diagnostics": &s.Build.Diagnostics,
+			"timings":     &s.Build.Timings,
+			"profile":     &s.Build.Profile,
+		}, nil
+	case "run":
+		return map[string]interface{}{"target": &s.Run.Target}, nil
+	case "test":
+		return map[string]interface{}{"args": &s.Test.Args}, nil
+	case "init":
+		return map[string]interface{}{"cxx_standard": &s.Init.CXXStandard, "layout": &s.Init.Layout}, nil
+	case "install":
+		return map[string]interface{}{"prefix": &s.Install.Prefix}, nil
+	case "package":
+		return map[string]interface{}{"format": &s.Package.Format, "vendor": &s.Package.Vendor, "contact": &s.Package.Contact}, nil
+	case "profile", "":
+		return nil, fmt.Errorf("settings belong in a table, e.g. [build], profiles in [profile.<name>]")
+	}
+
+	if name := strings.TrimPrefix(table, "profile."); name != table {
+		if strings.Contains(name, ".") {
+			return nil, fmt.Errorf("unknown table [%s], profiles are tables named [profile.<name>]", table)
+		}
+		profile := s.Profiles[name]
+		if profile == nil {
+			profile = &buildProfile{}
+			s.Profiles[name] = profile
+		}
+		return map[string]interface{}{
+			"compiler":  &profile.Compiler,
+			"config":    &profile.Config,
+			"toolchain": &profile.Toolchain,
+			"generator": &profile.Generator,
+			"sanitize":  &profile.Sanitize,
+			"define":    &profile.Define,
+		}, nil
+	}
+
+	message := fmt.Sprintf("unknown table [%s]", table)
+	if suggestion := closestName(table, settingsTables); suggestion != "" {
+		message += fmt.Sprintf(", did you mean [%s]?", suggestion)
+	}
+	return nil, fmt.Errorf("%s (expected %s)", message, strings.Join(settingsTables, ", "))
+}
+
+// assignSetting stores a TOML value in a settings field, checking its type
+func assignSetting(field interface{}, value interface{}) error {
+	switch field := field.(type) {
+	case *string:
+		if v, ok := value.(string); ok {
+			*field = v
+			return nil
+		}
+		return fmt.Errorf("expected a string, got %s", tomlTypeName(value))
+	case *int:
+		if v, ok := value.(int64); ok {
+			*field = int(v)
+			return nil
+		}
+		return fmt.Errorf("expected an integer, got %s", tomlTypeName(value))
+	case *bool:
+		if v, ok := value.(bool); ok {
+			*field = v
+			return nil
+		}
+		return fmt.Errorf("expected true or false, got %s", tomlTypeName(value))
+	case *[]string:
+		// A single string is a list of one
+		if v, ok := value.(string); ok {
+			*field = []string{v}
+			return nil
+		}
+		values, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("expected an array of strings, got %s", tomlTypeName(value))
+		}
+		list := []string{}
+		for _, item := range values {
+			s, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("expected an array of strings, found %s in it", tomlTypeName(item))
+			}
+			list = append(list, s)
+		}
+		*field = list
+		return nil
+	}
+	return fmt.Errorf("unsupported setting")
+}
+
+func tomlTypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case int64:
+		return "an integer"
+	case bool:
+		return "a boolean"
+	case []interface{}:
+		return "an array"
+	}
+	return "a value"
+}
+
+// closestName returns the candidate a mistyped name most likely meant, or an
+// empty string if none is close
+func closestName(name string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, candidate := range candidates {
+		if d := editDistance(name, candidate); d < bestDistance {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// readSettingsFile applies the entries of a settings file on top of the
+// settings read so far
+func (s *qsSettings) readSettingsFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	entries, err := parseTOML(path, string(data))
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		origin := fmt.Sprintf("%s:%d", path, entry.Line)
+		fields, err := s.fields(entry.Table)
+		if err != nil {
+			return fmt.Errorf("%s: %s", origin, err)
+		}
+		field, ok := fields[entry.Key]
+		if !ok {
+			var keys []string
+			for key := range fields {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			message := fmt.Sprintf("unknown key '%s' in [%s]", entry.Key, entry.Table)
+			if suggestion := closestName(entry.Key, keys); suggestion != "" {
+				message += fmt.Sprintf(", did you mean '%s'?", suggestion)
+			}
+			return fmt.Errorf("%s: %s (expected %s)", origin, message, strings.Join(keys, ", "))
+		}
+		if err := assignSetting(field, entry.Value); err != nil {
+			return fmt.Errorf("%s: %s.%s: %s", origin, entry.Table, entry.Key, err)
+		}
+		s.origins[entry.Table+"."+entry.Key] = origin
+	}
+	return nil
+}
+
+// validate checks the values of the settings, and normalizes build types
+// and standards to the spelling CMake uses
+func (s *qsSettings) validate() error {
+	fail := func(key string, format string, args ...interface{}) error {
+		return fmt.Errorf("%s: %s: %s", s.origins[key], key, fmt.Sprintf(format, args...))
+	}
+	set := func(key string) bool {
+		_, ok := s.origins[key]
+		return ok
+	}
+
+	if set("build.dir") {
+		dir := filepath.Clean(s.Build.Dir)
+		if s.Build.Dir == "" || filepath.IsAbs(dir) || dir == "." || strings.HasPrefix(dir, "..") {
+			return fail("build.dir", "must be a directory inside the project, e.g. \"build\"")
+		}
+		s.Build.Dir = dir
+	}
+	if s.Build.Config != "" {
+		config, err := lookupConfig(s.Build.Config)
+		if err != nil {
+			return fail("build.config", "%s", err)
+		}
+		s.Build.Config = config
+	}
+	if set("build.jobs") && s.Build.Jobs < 1 {
+		return fail("build.jobs", "must be at least 1")
+	}
+	if s.Build.Launcher != "" {
+		launcher := strings.ToLower(s.Build.Launcher)
+		if launcher != "auto" && launcher != "none" && launcher != "off" && !containsWord(compilerLaunchers, launcher) {
+			return fail("build.launcher", "unknown compiler launcher '%s' (expected auto, ccache, sccache or none)", s.Build.Launcher)
+		}
+		s.Build.Launcher = launcher
+	}
+	if _, ok := diagnosticFormats[s.Build.Diagnostics]; s.Build.Diagnostics != "" && !ok {
+		return fail("build.diagnostics", "unknown format '%s' (expected json or sarif)", s.Build.Diagnostics)
+	}
+	if s.Build.Profile != "" && s.Profiles[s.Build.Profile] == nil {
+		return fail("build.profile", "no profile named '%s'%s", s.Build.Profile, profileList(s.Profiles))
+	}
+	if s.Init.CXXStandard != "" {
+		value, ok := cxxStandards[s.Init.CXXStandard]
+		if !ok {
+			return fail("init.cxx_standard", "unknown C++ standard '%s' (expected 98, 11, 14, 17, 20, 23 or 26)", s.Init.CXXStandard)
+		}
+		s.Init.CXXStandard = value
+	}
+	if s.Init.Layout != "" && !isValidSubLayout(s.Init.Layout) {
+		return fail("init.layout", "unknown layout '%s' (expected %s or %s)", s.Init.Layout, layoutFlat, layoutNamespaced)
+	}
+	if s.Package.Format != "" {
+		s.Package.Format = strings.ToLower(s.Package.Format)
+		if _, ok := packageFormats[s.Package.Format]; !ok {
+			return fail("package.format", "unknown package format '%s' (expected tgz, zip, deb or rpm)", s.Package.Format)
+		}
+	}
+
+	// Compilers and toolchains are checked when a profile is used, they may
+	// be missing on machines that never build it
+	for name, profile := range s.Profiles {
+		key := func(field string) string { return "profile." + name + "." + field }
+		if profile.Config != "" {
+			config, err := lookupConfig(profile.Config)
+			if err != nil {
+				return fail(key("config"), "%s", err)
+			}
+			profile.Config = config
+		}
+		if len(profile.Sanitize) > 0 {
+			if _, err := parseSanitizers(strings.Join(profile.Sanitize, ",")); err != nil {
+				return fail(key("sanitize"), "%s", err)
+			}
+		}
+		for _, definition := range profile.Define {
+			if _, err := parseCacheDefinition(definition); err != nil {
+				return fail(key("define"), "%s", err)
+			}
+		}
+		if profile.Compiler != "" && profile.Toolchain != "" {
+			return fail(key("toolchain"), "a profile cannot set both a compiler and a toolchain, the toolchain picks the compilers")
+		}
+	}
+	return nil
+}
+
+// profileList lists the defined profiles for error messages
+func profileList(profiles map[string]*buildProfile) string {
+	if len(profiles) == 0 {
+		return ", no profiles are defined"
+	}
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return " (defined: " + strings.Join(names, ", ") + ")"
+}
+
+// loadSettings reads the user's settings file, then the project's qs.toml,
+// whose settings take precedence
+func loadSettings() error {
+	s := qsSettings{Profiles: map[string]*buildProfile{}, origins: map[string]string{}}
+	if path := userSettingsFile(); path != "" {
+		if err := s.readSettingsFile(path); err != nil {
+			return err
+		}
+	}
+	if err := s.readSettingsFile(projectSettingsFile); err != nil {
+		return err
+	}
+	if err := s.validate(); err != nil {
+		return err
+	}
+
+	settings = s
+	if s.Build.Dir != "" {
+		buildRoot = s.Build.Dir
+	}
+	return nil
+}
+
+// extractProfile removes --profile <name> from args and returns the name
+func extractProfile(args []string) (string, []string, error) {
+	name := ""
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--profile":
+			if i+1 >= len(args) {
+				return "", nil, fmt.Errorf("'--profile' requires a profile name")
+			}
+			i++
+			name = args[i]
+		case strings.HasPrefix(arg, "--profile="):
+			name = strings.TrimPrefix(arg, "--profile=")
+		default:
+			rest = append(rest, arg)
+		}
+	}
+	return name, rest, nil
+}
+
+// applyProfile sets the build options of a profile. Options given on the
+// command line are applied afterwards and take precedence.
+func applyProfile(opts *buildOptions, name string) error {
+	profile := settings.Profiles[name]
+	if profile == nil {
+		return fmt.Errorf("no profile named '%s'%s", name, profileList(settings.Profiles))
+	}
+
+	if profile.Compiler != "" {
+		compiler, err := resolveCompiler(profile.Compiler)
+		if err != nil {
+			return fmt.Errorf("profile '%s': %s", name, err)
+		}
+		opts.Compiler = compiler
+	}
+	if profile.Toolchain != "" {
+		if !fileExists(toolchainFile(profile.Toolchain)) {
+			return fmt.Errorf("profile '%s': toolchain '%s' not found (expected %s)", name, profile.Toolchain, toolchainFile(profile.Toolchain))
+		}
+		opts.Toolchain = profile.Toolchain
+	}
+	if profile.Generator != "" {
+		opts.Generator = profile.Generator
+	}
+	if len(profile.Sanitize) > 0 {
+		opts.Sanitizers, _ = parseSanitizers(strings.Join(profile.Sanitize, ","))
+	}
+	for _, definition := range profile.Define {
+		entry, _ := parseCacheDefinition(definition)
+		opts.CacheVars = mergeCacheEntries(opts.CacheVars, []cacheEntry{entry})
+	}
+	return nil
+}
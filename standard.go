@@ -0,0 +1,503 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// standardOptions holds the arguments of 'qs std'
+type standardOptions struct {
+	CXX        string // CMake C++ standard value, empty to leave unchanged
+	C          string // CMake C standard value, empty to leave unchanged
+	Extensions string // "ON", "OFF" or empty to leave unchanged
+	Target     string // when set, the standard applies to this target only
+	Verify     bool   // check the standards against the installed compilers instead of editing
+}
+
+// cxxStandards maps accepted 'qs std' arguments to CMake CXX_STANDARD values.
+// CMake has no separate value for C++03, it is covered by 98.
+var cxxStandards = map[string]string{
+	"98": "98",
+	"03": "98",
+	"11": "11",
+	"14": "14",
+	"17": "17",
+	"20": "20",
+	"23": "23",
+	"26": "26",
+}
+
+// cStandards lists the accepted C_STANDARD values
+var cStandards = map[string]string{
+	"90": "90",
+	"99": "99",
+	"11": "11",
+	"17": "17",
+	"23": "23",
+}
+
+// cmakeStandardVersions lists the CMake release that first knows each
+// CMAKE_<LANG>_STANDARD value; older releases reject the project
+var cmakeStandardVersions = map[string]map[string]string{
+	"CXX": {"20": "3.12", "23": "3.20", "26": "3.25"},
+	"C":   {"17": "3.21", "23": "3.21"},
+}
+
+// cxxStandardFlags lists the -std= spellings to try for each C++ standard,
+// newest spelling first; older compilers only know the provisional names
+var cxxStandardFlags = []struct {
+	Value string
+	Flags []string
+}{
+	{"98", []string{"c++98"}},
+	{"11", []string{"c++11", "c++0x"}},
+	{"14", []string{"c++14", "c++1y"}},
+	{"17", []string{"c++17", "c++1z"}},
+	{"20", []string{"c++20", "c++2a"}},
+	{"23", []string{"c++23", "c++2b"}},
+	{"26", []string{"c++26", "c++2c"}},
+}
+
+// cStandardFlags lists the -std= spellings to try for each C standard
+var cStandardFlags = []struct {
+	Value string
+	Flags []string
+}{
+	{"90", []string{"c90", "c89"}},
+	{"99", []string{"c99"}},
+	{"11", []string{"c11"}},
+	{"17", []string{"c17", "c18"}},
+	{"23", []string{"c23", "c2x"}},
+}
+
+// featureProbe is a snippet that only compiles when a library or language
+// feature is available
+type featureProbe struct {
+	Name     string
+	Standard string // minimum C++ standard the feature needs
+	Source   string
+}
+
+var featureProbes = []featureProbe{
+	{
+		Name:     "concepts",
+		Standard: "20",
+		Source: `#include <concepts>
+template <typename T> concept Number = std::integral<T> || std::floating_point<T>;
+template <Number T> T twice(T value) { return value * 2; }
+int main() { return twice(0); }
+`,
+	},
+	{
+		Name:     "modules",
+		Standard: "20",
+		Source: `#ifndef __cpp_modules
+#error "modules are not supported"
+#endif
+int main() { return 0; }
+`,
+	},
+	{
+		Name:     "<format>",
+		Standard: "20",
+		Source: `#include <format>
+#include <string>
+int main() { std::string s = std::format("{} {}", "qs", 42); return s.empty(); }
+`,
+	},
+	{
+		Name:     "std::expected",
+		Standard: "23",
+		Source: `#include <expected>
+int main() { std::expected<int, int> value = 1; return value.has_value() ? 0 : 1; }
+`,
+	},
+}
+
+// parseStandardArgs parses the arguments following 'qs std'
+func parseStandardArgs(args []string) (standardOptions, error) {
+	var opts standardOptions
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		value := ""
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			parts := strings.SplitN(arg, "=", 2)
+			arg, value = parts[0], parts[1]
+		}
+
+		switch arg {
+		case "--verify":
+			opts.Verify = true
+		case "--c", "--extensions", "--target":
+			if value == "" {
+				if i+1 >= len(args) {
+					return opts, fmt.Errorf("'%s' requires a value", arg)
+				}
+				i++
+				value = args[i]
+			}
+			switch arg {
+			case "--c":
+				std, ok := cStandards[value]
+				if !ok {
+					return opts, fmt.Errorf("invalid C standard '%s' (expected 90, 99, 11, 17 or 23)", value)
+				}
+				opts.C = std
+			case "--extensions":
+				switch strings.ToLower(value) {
+				case "on":
+					opts.Extensions = "ON"
+				case "off":
+					opts.Extensions = "OFF"
+				default:
+					return opts, fmt.Errorf("invalid value '%s' for --extensions (expected on or off)", value)
+				}
+			case "--target":
+				opts.Target = value
+			}
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, fmt.Errorf("unknown option '%s'", arg)
+			}
+			std, ok := cxxStandards[strings.TrimPrefix(strings.ToLower(arg), "c++")]
+			if !ok {
+				return opts, fmt.Errorf("invalid C++ standard '%s' (expected 98, 03, 11, 14, 17, 20, 23 or 26)", arg)
+			}
+			opts.CXX = std
+		}
+	}
+
+	return opts, nil
+}
+
+// setCMakeVariable sets a variable with set(NAME VALUE), replacing an existing
+// set() of the same variable. New variables are placed right after project()
+// so they apply to every target defined below it.
+func setCMakeVariable(content string, name string, value string) (string, bool) {
+	re := regexp.MustCompile(fmt.Sprintf(`(?m)^([ \t]*)set\(%s\s+[^)]*\)`, regexp.QuoteMeta(name)))
+	if re.MatchString(content) {
+		return re.ReplaceAllString(content, fmt.Sprintf("${1}set(%s %s)", name, value)), true
+	}
+
+	line := fmt.Sprintf("set(%s %s)\n", name, value)
+	anchor := regexp.MustCompile(`(?m)^[ \t]*(set\(CMAKE_C(XX)?_(STANDARD|STANDARD_REQUIRED|EXTENSIONS)\s+[^)]*\)|project\([^)]*\))[ \t]*\n`)
+	locations := anchor.FindAllStringIndex(content, -1)
+	if len(locations) == 0 {
+		return content + "\n" + line, false
+	}
+	end := locations[len(locations)-1][1]
+	return content[:end] + line + content[end:], false
+}
+
+// findTargetFile returns the CMakeLists.txt that defines a target, or an
+// empty string if no add_executable/add_library call for it exists
+func findTargetFile(targetName string) string {
+	re := targetDefinitionRegex(targetName)
+	found := ""
+	walkProjectFiles(".", func(path string) {
+		if found != "" || !isCMakeFile(path) {
+			return
+		}
+		content, err := os.ReadFile(path)
+		if err == nil && re.Match(content) {
+			found = path
+		}
+	})
+	return found
+}
+
+// targetDefinitionRegex matches the whole add_executable/add_library call of a target
+func targetDefinitionRegex(targetName string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`add_(?:executable|library)\(%s(?:\s[^)]*)?\)[ \t]*\n?`, regexp.QuoteMeta(targetName)))
+}
+
+// setTargetStandard sets language standards on a single target with
+// target_compile_features instead of the global CMAKE_<LANG>_STANDARD
+func setTargetStandard(opts standardOptions) {
+	if opts.CXX == "" && opts.C == "" && opts.Extensions == "" {
+		fmt.Println("Error: 'qs std --target' requires a standard, e.g. 'qs std --target app 20'")
+		return
+	}
+
+	cmakePath := findTargetFile(opts.Target)
+	if cmakePath == "" {
+		fmt.Printf("Error: Target '%s' not found in any CMakeLists.txt\n", opts.Target)
+		return
+	}
+
+	cmakelists, err := os.ReadFile(cmakePath)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", cmakePath, err)
+		return
+	}
+	content := string(cmakelists)
+
+	if opts.CXX != "" {
+		content = setTargetProperty(content, opts.Target, "target_compile_features", "cxx_std_", "PUBLIC cxx_std_"+opts.CXX)
+		fmt.Printf("Set C++ standard of target '%s' to C++%s\n", opts.Target, opts.CXX)
+	}
+	if opts.C != "" {
+		content = setTargetProperty(content, opts.Target, "target_compile_features", "c_std_", "PUBLIC c_std_"+opts.C)
+		fmt.Printf("Set C standard of target '%s' to C%s\n", opts.Target, opts.C)
+	}
+	if opts.Extensions != "" {
+		content = setTargetProperty(content, opts.Target, "set_target_properties", "CXX_EXTENSIONS", "PROPERTIES CXX_EXTENSIONS "+opts.Extensions)
+		fmt.Printf("Set compiler extensions of target '%s' to %s\n", opts.Target, opts.Extensions)
+	}
+
+	if cmakePath == "CMakeLists.txt" {
+		content = raiseCMakeMinimum(content, opts)
+	} else if root, err := os.ReadFile("CMakeLists.txt"); err == nil {
+		if raised := raiseCMakeMinimum(string(root), opts); raised != string(root) {
+			if err := os.WriteFile("CMakeLists.txt", []byte(raised), 0644); err != nil {
+				fmt.Printf("Error updating CMakeLists.txt: %v\n", err)
+			}
+		}
+	}
+
+	err = os.WriteFile(cmakePath, []byte(content), 0644)
+	if err != nil {
+		fmt.Printf("Error updating %s: %v\n", cmakePath, err)
+	}
+}
+
+// raiseCMakeMinimum raises cmake_minimum_required to the first CMake release
+// that knows the requested standards, since older releases reject them
+func raiseCMakeMinimum(content string, opts standardOptions) string {
+	needed, standard := "", ""
+	for _, req := range [][3]string{{"CXX", opts.CXX, "C++"}, {"C", opts.C, "C"}} {
+		if version := cmakeStandardVersions[req[0]][req[1]]; version != "" && (needed == "" || !versionAtLeast(needed, version)) {
+			needed, standard = version, req[2]+req[1]
+		}
+	}
+	current := cmakeMinimumVersion(content)
+	if needed == "" || current == "" || versionAtLeast(current, needed) {
+		return content
+	}
+
+	// A <min>...<max> range keeps its maximum unless that is too old as well
+	re := regexp.MustCompile(`(?i)(cmake_minimum_required\s*\(\s*VERSION\s+)\d+(?:\.\d+)*(?:\.\.\.(\d+(?:\.\d+)*))?`)
+	content = re.ReplaceAllStringFunc(content, func(call string) string {
+		match := re.FindStringSubmatch(call)
+		if match[2] != "" && versionAtLeast(match[2], needed) {
+			return match[1] + needed + "..." + match[2]
+		}
+		return match[1] + needed
+	})
+	fmt.Printf("Raised cmake_minimum_required from %s to %s, the first CMake release that supports %s\n", current, needed, standard)
+	return content
+}
+
+// setTargetProperty updates the first argument starting with prefix in a
+// command(<target> ...) call, or adds a new call after the target definition.
+// Properties set through set_target_properties are followed by their value.
+func setTargetProperty(content string, targetName string, command string, prefix string, args string) string {
+	newArgs := strings.Fields(args)
+	value := newArgs[len(newArgs)-1]
+	updated := false
+
+	content = editCommandArgs(content, command, func(callArgs []string) []string {
+		if updated || len(callArgs) == 0 || callArgs[0] != targetName {
+			return callArgs
+		}
+		for i, arg := range callArgs {
+			if !strings.HasPrefix(arg, prefix) {
+				continue
+			}
+			if command == "set_target_properties" {
+				if i+1 < len(callArgs) {
+					callArgs[i+1] = value
+					updated = true
+				}
+			} else {
+				callArgs[i] = value
+				updated = true
+			}
+			break
+		}
+		return callArgs
+	})
+	if updated {
+		return content
+	}
+
+	line := fmt.Sprintf("%s(%s %s)\n", command, targetName, args)
+	location := targetDefinitionRegex(targetName).FindStringIndex(content)
+	if location == nil {
+		return content + line
+	}
+	end := location[1]
+	if end == 0 || content[end-1] != '\n' {
+		line = "\n" + line
+	}
+	return content[:end] + line + content[end:]
+}
+
+// hasCMakeVariable reports whether content sets a CMake variable
+func hasCMakeVariable(content string, name string) bool {
+	matched, _ := regexp.MatchString(fmt.Sprintf(`set\(%s\s`, regexp.QuoteMeta(name)), content)
+	return matched
+}
+
+// getCMakeVariable returns the value of set(NAME VALUE) in content, or an
+// empty string if the variable is not set
+func getCMakeVariable(content string, name string) string {
+	re := regexp.MustCompile(fmt.Sprintf(`set\(%s\s+([^)\s]+)`, regexp.QuoteMeta(name)))
+	match := re.FindStringSubmatch(content)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+// supportedStandardFlag returns the -std= flag the compiler accepts for a
+// standard, or an empty string if it does not support it
+func supportedStandardFlag(compiler compilerInfo, lang string, flags []string) string {
+	for _, flag := range flags {
+		if compiler.compiles("int main(void) { return 0; }\n", lang, "-std="+flag) {
+			return "-std=" + flag
+		}
+	}
+	return ""
+}
+
+// verifyStandards trial-compiles small programs to report which language
+// standards and notable features the installed compilers support
+func verifyStandards(opts standardOptions) {
+	cmakeContent := ""
+	if cmakelists, err := os.ReadFile("CMakeLists.txt"); err == nil {
+		cmakeContent = string(cmakelists)
+	}
+
+	// The standard to check: the one given on the command line, else the configured one
+	wantCXX := opts.CXX
+	if wantCXX == "" {
+		wantCXX = getCMakeVariable(cmakeContent, "CMAKE_CXX_STANDARD")
+	}
+	wantC := opts.C
+	if wantC == "" {
+		wantC = getCMakeVariable(cmakeContent, "CMAKE_C_STANDARD")
+	}
+
+	cxx, found := findCXXCompiler()
+	if !found {
+		fmt.Println("Error: No C++ compiler found. Install g++ or clang++, or set CXX.")
+	} else {
+		fmt.Printf("C++ compiler: %s\n", cxx)
+		fmt.Println("\nC++ standards:")
+
+		supported := map[string]string{}
+		newestFlag := ""
+		newest := ""
+		for _, std := range cxxStandardFlags {
+			flag := supportedStandardFlag(cxx, ".cpp", std.Flags)
+			status := "no"
+			if flag != "" {
+				supported[std.Value] = flag
+				newestFlag = flag
+				newest = std.Value
+				status = "yes (" + flag + ")"
+			}
+			fmt.Printf("  C++%-4s %s\n", std.Value, status)
+		}
+
+		if newestFlag != "" {
+			fmt.Printf("\nFeatures (using %s):\n", newestFlag)
+			for _, probe := range featureProbes {
+				status := "no"
+				if standardAtLeast(newest, probe.Standard) {
+					flags := []string{newestFlag}
+					if probe.Name == "modules" && cxx.ID == "GNU" {
+						// GCC only enables modules on request
+						flags = append(flags, "-fmodules-ts")
+					}
+					if cxx.compiles(probe.Source, ".cpp", flags...) {
+						status = "yes"
+					}
+				} else {
+					status = "no (needs C++" + probe.Standard + ")"
+				}
+				fmt.Printf("  %-15s %s\n", probe.Name, status)
+			}
+		}
+
+		if wantCXX != "" {
+			if flag, ok := supported[wantCXX]; ok {
+				fmt.Printf("\nRequested C++%s is supported (%s)\n", wantCXX, flag)
+			} else {
+				fmt.Printf("\nWarning: Requested C++%s is NOT supported by %s\n", wantCXX, cxx.Path())
+				fmt.Println("Use a newer compiler (set CXX) or choose an older standard with 'qs std'.")
+			}
+		}
+	}
+
+	// Only look at the C compiler when the project asks for a C standard
+	if wantC == "" {
+		return
+	}
+
+	cc, found := findCCompiler()
+	if !found {
+		fmt.Println("\nError: No C compiler found. Install gcc or clang, or set CC.")
+		return
+	}
+	fmt.Printf("\nC compiler: %s\n", cc)
+	fmt.Println("\nC standards:")
+	wantSupported := false
+	for _, std := range cStandardFlags {
+		flag := supportedStandardFlag(cc, ".c", std.Flags)
+		status := "no"
+		if flag != "" {
+			status = "yes (" + flag + ")"
+			if std.Value == wantC {
+				wantSupported = true
+			}
+		}
+		fmt.Printf("  C%-4s %s\n", std.Value, status)
+	}
+	if wantSupported {
+		fmt.Printf("\nRequested C%s is supported\n", wantC)
+	} else {
+		fmt.Printf("\nWarning: Requested C%s is NOT supported by %s\n", wantC, cc.Path())
+	}
+}
+
+// standardAtLeast compares two C++ standard values, treating 98 as the oldest
+func standardAtLeast(value string, minimum string) bool {
+	order := map[string]int{}
+	for i, std := range cxxStandardFlags {
+		order[std.Value] = i
+	}
+	return order[value] >= order[minimum]
+}
+
+// versionAtLeast compares dotted version numbers, e.g. "3.22.1" >= "3.10"
+func versionAtLeast(version string, minimum string) bool {
+	have := strings.Split(version, ".")
+	want := strings.Split(minimum, ".")
+	for i := 0; i < len(want); i++ {
+		w, _ := strconv.Atoi(want[i])
+		h := 0
+		if i < len(have) {
+			h, _ = strconv.Atoi(have[i])
+		}
+		if h != w {
+			return h > w
+		}
+	}
+	return true
+}
+
+// cmakeMinimumVersion returns the version in cmake_minimum_required of a
+// CMakeLists.txt, the lower end of a range like 3.10...3.28
+func cmakeMinimumVersion(content string) string {
+	re := regexp.MustCompile(`(?i)cmake_minimum_required\s*\(\s*VERSION\s+(\d+(\.\d+)*)`)
+	if match := re.FindStringSubmatch(content); match != nil {
+		return match[1]
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// buildStateFile is where qs keeps per build directory state
+const buildStateFile = ".qs-state.json"
+
+// buildState is what qs remembers about a build directory between runs
+type buildState struct {
+	// InputsHash covers every CMake input and the qs options used for the
+	// last successful configure
+	InputsHash string `json:"inputs_hash"`
+	// CacheVars are the -D options given to qs build or qs config set,
+	// passed to every configure of this build directory
+	CacheVars []cacheEntry `json:"cache_vars,omitempty"`
+	// Sanitizers the directory is built with, so qs run and qs test can set
+	// their runtime options
+	Sanitizers []string `json:"sanitizers,omitempty"`
+	// Launcher is the compiler cache qs configured the directory with
+	Launcher string `json:"launcher,omitempty"`
+}
+
+// loadBuildState reads the state of a build directory. A missing or
+// unreadable state file gives an empty state.
+func loadBuildState(buildDir string) buildState {
+	var state buildState
+	data, err := os.ReadFile(filepath.Join(buildDir, buildStateFile))
+	if err != nil {
+		return state
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return buildState{}
+	}
+	return state
+}
+
+// saveBuildState writes the state of a build directory
+func saveBuildState(buildDir string, state buildState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(buildDir, buildStateFile), append(data, '\n'), 0644)
+}
+
+// isCMakeInput reports whether a file is read by CMake at configure time
+func isCMakeInput(path string) bool {
+	name := filepath.Base(path)
+	return isCMakeFile(path) || name == "CMakePresets.json" || name == "CMakeUserPresets.json"
+}
+
+// hashCMakeInputs hashes the contents of all CMake inputs, the list of source
+// files (so file(GLOB) picks up added and removed files) and the qs options
+// that affect the configure step
+func hashCMakeInputs(options string) string {
+	var cmakeFiles, sourceFiles []string
+	walkProjectFiles(".", func(path string) {
+		if isCMakeInput(path) {
+			cmakeFiles = append(cmakeFiles, path)
+		} else if isSourceFile(path) {
+			sourceFiles = append(sourceFiles, path)
+		}
+	})
+	sort.Strings(cmakeFiles)
+	sort.Strings(sourceFiles)
+
+	hash := sha256.New()
+	hash.Write([]byte("options\x00" + options + "\x00"))
+	for _, path := range cmakeFiles {
+		hash.Write([]byte("cmake\x00" + filepath.ToSlash(path) + "\x00"))
+		if data, err := os.ReadFile(path); err == nil {
+			hash.Write(data)
+		}
+		hash.Write([]byte{0})
+	}
+	for _, path := range sourceFiles {
+		hash.Write([]byte("source\x00" + filepath.ToSlash(path) + "\x00"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
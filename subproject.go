@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// isSubProject reports whether dir looks like a sub-project created by 'qs init sub'
+func isSubProject(dir string) bool {
+	content, err := os.ReadFile(filepath.Join(dir, "CMakeLists.txt"))
+	if err != nil {
+		return false
+	}
+	pattern := fmt.Sprintf(`add_library\(%s[\s)]`, regexp.QuoteMeta(filepath.Base(dir)))
+	matched, _ := regexp.MatchString(pattern, string(content))
+	return matched
+}
+
+// subProjectLayout detects the header layout of an existing sub-project
+func subProjectLayout(name string) string {
+	if fileExists(filepath.Join(name, "include", name, name+".hpp")) {
+		return layoutNamespaced
+	}
+	return layoutFlat
+}
+
+// removeSubProject deletes a sub-project and every reference qs generated for it
+func removeSubProject(name string, assumeYes bool) {
+	name = filepath.Clean(name)
+
+	if !fileExists("CMakeLists.txt") {
+		fmt.Println("Error: Main CMakeLists.txt not found in the current directory.")
+		return
+	}
+
+	if !isSubProject(name) {
+		fmt.Printf("Error: '%s' is not a sub-project (no add_library(%s) in %s/CMakeLists.txt)\n", name, name, name)
+		return
+	}
+
+	if !assumeYes && !confirm(fmt.Sprintf("Remove sub-project '%s' and delete the '%s' directory?", name, name)) {
+		fmt.Println("Aborted.")
+		return
+	}
+
+	parentCMake, err := os.ReadFile("CMakeLists.txt")
+	if err != nil {
+		fmt.Printf("Error reading parent CMakeLists.txt: %v\n", err)
+		return
+	}
+
+	content := string(parentCMake)
+	content = removeGeneratedComments(content, name)
+	content = editCommandArgs(content, "add_subdirectory", func(args []string) []string {
+		if len(args) > 0 && filepath.Clean(args[0]) == name {
+			return nil
+		}
+		return args
+	})
+	content = editCommandArgs(content, "target_link_libraries", func(args []string) []string {
+		return removeLinkedLibrary(args, name)
+	})
+	content = editCommandArgs(content, "install", func(args []string) []string {
+		return removeInstalledTarget(args, name)
+	})
+
+	err = os.WriteFile("CMakeLists.txt", []byte(content), 0644)
+	if err != nil {
+		fmt.Printf("Error updating parent CMakeLists.txt: %v\n", err)
+		return
+	}
+	fmt.Printf("Removed references to '%s' from parent CMakeLists.txt\n", name)
+
+	err = os.RemoveAll(name)
+	if err != nil {
+		fmt.Printf("Error removing directory '%s': %v\n", name, err)
+		return
+	}
+	fmt.Printf("Deleted directory '%s'\n", name)
+	refreshInstallRules()
+
+	reportReferences(name)
+}
+
+// subProjectNameRegex matches names that work as a directory, a CMake target
+// and a C++ namespace, which a sub-project's name is used as
+var subProjectNameRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// sampleRename is a sample file written by 'qs init sub' to move and rewrite
+// when a sub-project is renamed
+type sampleRename struct {
+	From    string
+	To      string
+	Content string
+}
+
+// renameSubProject renames a sub-project directory, its library target,
+// namespace and sample files, and updates the parent CMakeLists.txt. All
+// edits are worked out before any file is written, and the directory is
+// renamed last.
+func renameSubProject(oldName string, newName string) {
+	oldName = filepath.Clean(oldName)
+	newName = filepath.Clean(newName)
+
+	if !fileExists("CMakeLists.txt") {
+		fmt.Println("Error: Main CMakeLists.txt not found in the current directory.")
+		return
+	}
+
+	if !isSubProject(oldName) {
+		fmt.Printf("Error: '%s' is not a sub-project (no add_library(%s) in %s/CMakeLists.txt)\n", oldName, oldName, oldName)
+		return
+	}
+
+	if !subProjectNameRegex.MatchString(newName) {
+		fmt.Printf("Error: '%s' is not a valid sub-project name; it names the directory, the CMake target and the C++ namespace, so use letters, digits and underscores, not starting with a digit\n", newName)
+		return
+	}
+
+	if _, err := os.Stat(newName); err == nil {
+		fmt.Printf("Error: '%s' already exists\n", newName)
+		return
+	}
+
+	layout := subProjectLayout(oldName)
+
+	// Sub-project CMakeLists.txt
+	subCMakePath := filepath.Join(oldName, "CMakeLists.txt")
+	subCMake, err := os.ReadFile(subCMakePath)
+	if err != nil {
+		fmt.Printf("Error reading %s: %v\n", subCMakePath, err)
+		return
+	}
+	subContent := string(subCMake)
+	subContent = strings.Replace(subContent, "# "+oldName+" sub-project", "# "+newName+" sub-project", 1)
+	subContent = strings.ReplaceAll(subContent, "include/"+oldName+"/", "include/"+newName+"/")
+	for _, command := range []string{"add_library", "target_link_libraries", "target_include_directories"} {
+		subContent = editCommandArgs(subContent, command, func(args []string) []string {
+			if len(args) > 0 && args[0] == oldName {
+				args[0] = newName
+			}
+			return args
+		})
+	}
+	subContent = editCommandArgs(subContent, "install", func(args []string) []string {
+		return renameInstalledTarget(args, oldName, newName)
+	})
+
+	// Sample header and source, with paths relative to the sub-project. A
+	// namespaced header is renamed within its directory, which is renamed
+	// after it.
+	var samples []sampleRename
+	oldHeader := filepath.Join("include", filepath.FromSlash(subHeaderPath(oldName, layout)))
+	if fileExists(filepath.Join(oldName, oldHeader)) {
+		newHeader := filepath.Join(filepath.Dir(oldHeader), filepath.Base(filepath.FromSlash(subHeaderPath(newName, layout))))
+		samples = append(samples, sampleRename{From: oldHeader, To: newHeader})
+	}
+	oldSource := filepath.Join("src", oldName+".cc")
+	if fileExists(filepath.Join(oldName, oldSource)) {
+		samples = append(samples, sampleRename{From: oldSource, To: filepath.Join("src", newName+".cc")})
+	}
+	headerDir := filepath.Join(oldName, "include", newName)
+	if _, err := os.Stat(headerDir); err == nil && layout == layoutNamespaced {
+		fmt.Printf("Error: '%s' already exists\n", headerDir)
+		return
+	}
+	for i, sample := range samples {
+		if fileExists(filepath.Join(oldName, sample.To)) {
+			fmt.Printf("Error: '%s' already exists\n", filepath.Join(oldName, sample.To))
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(oldName, sample.From))
+		if err != nil {
+			fmt.Printf("Error reading %s: %v\n", filepath.Join(oldName, sample.From), err)
+			return
+		}
+		samples[i].Content = renameGeneratedContent(string(data), oldName, newName, layout)
+	}
+
+	// Parent CMakeLists.txt
+	parentCMake, err := os.ReadFile("CMakeLists.txt")
+	if err != nil {
+		fmt.Printf("Error reading parent CMakeLists.txt: %v\n", err)
+		return
+	}
+	content := string(parentCMake)
+	content = strings.ReplaceAll(content, "# Include the "+oldName+" sub-project", "# Include the "+newName+" sub-project")
+	content = strings.ReplaceAll(content, "# Link the "+oldName+" sub-project", "# Link the "+newName+" sub-project")
+	content = editCommandArgs(content, "add_subdirectory", func(args []string) []string {
+		if len(args) > 0 && filepath.Clean(args[0]) == oldName {
+			args[0] = newName
+		}
+		return args
+	})
+	content = editCommandArgs(content, "target_link_libraries", func(args []string) []string {
+		for i := range args {
+			if args[i] == oldName {
+				args[i] = newName
+			}
+		}
+		return args
+	})
+	content = editCommandArgs(content, "install", func(args []string) []string {
+		return renameInstalledTarget(args, oldName, newName)
+	})
+
+	// Everything is worked out, apply the edits
+	for _, sample := range samples {
+		from := filepath.Join(oldName, sample.From)
+		to := filepath.Join(oldName, sample.To)
+		if err := os.WriteFile(to, []byte(sample.Content), 0644); err != nil {
+			fmt.Printf("Error writing %s: %v\n", to, err)
+			return
+		}
+		if err := os.Remove(from); err != nil {
+			fmt.Printf("Error removing %s: %v\n", from, err)
+			return
+		}
+	}
+	if layout == layoutNamespaced && isDir(filepath.Join(oldName, "include", oldName)) {
+		err = os.Rename(filepath.Join(oldName, "include", oldName), headerDir)
+		if err != nil {
+			fmt.Printf("Error renaming header directory: %v\n", err)
+			return
+		}
+	}
+
+	err = os.WriteFile(subCMakePath, []byte(subContent), 0644)
+	if err != nil {
+		fmt.Printf("Error updating %s: %v\n", subCMakePath, err)
+		return
+	}
+
+	err = os.WriteFile("CMakeLists.txt", []byte(content), 0644)
+	if err != nil {
+		fmt.Printf("Error updating parent CMakeLists.txt: %v\n", err)
+		return
+	}
+
+	err = os.Rename(oldName, newName)
+	if err != nil {
+		fmt.Printf("Error renaming directory '%s' to '%s': %v\n", oldName, newName, err)
+		return
+	}
+	fmt.Printf("Renamed directory '%s' to '%s'\n", oldName, newName)
+
+	refreshInstallRules()
+
+	fmt.Printf("Renamed sub-project '%s' to '%s'\n", oldName, newName)
+	reportReferences(oldName)
+}
+
+// renameGeneratedContent updates the namespace and include line of a sample
+// file written by 'qs init sub' to the new name
+func renameGeneratedContent(content string, oldName string, newName string, layout string) string {
+	content = strings.ReplaceAll(content, "namespace "+oldName+" {", "namespace "+newName+" {")
+	content = strings.ReplaceAll(content, "} // namespace "+oldName, "} // namespace "+newName)
+	content = strings.ReplaceAll(content, "#include \""+subHeaderPath(oldName, layout)+"\"", "#include \""+subHeaderPath(newName, layout)+"\"")
+	content = strings.ReplaceAll(content, "Hello from "+oldName+" library!", "Hello from "+newName+" library!")
+	return content
+}
+
+// removeGeneratedComments drops the comment lines 'qs init sub' writes above
+// the add_subdirectory and target_link_libraries calls it adds, along with
+// the blank line written before each of them
+func removeGeneratedComments(content string, name string) string {
+	re := regexp.MustCompile(fmt.Sprintf(`(?m)\n?^# (Include|Link) the %s sub-project\n`, regexp.QuoteMeta(name)))
+	return re.ReplaceAllString(content, "")
+}
+
+// editCommandArgs rewrites the arguments of every call to a CMake command.
+// Arguments are replaced or dropped in place so the call keeps its formatting.
+// If fn returns nil the call is removed together with its line. Calls in
+// comments are left alone.
+func editCommandArgs(content string, command string, fn func(args []string) []string) string {
+	re := regexp.MustCompile(fmt.Sprintf(`(?m)(^[ \t]*)?\b%s\(([^)]*)\)([ \t]*\n)?`, regexp.QuoteMeta(command)))
+	tokenRe := regexp.MustCompile(`\s*\S+`)
+	edit := func(indent string, argText string, newline string) string {
+		tokens := tokenRe.FindAllString(argText, -1)
+		args := strings.Fields(argText)
+
+		newArgs := fn(append([]string{}, args...))
+		if newArgs == nil {
+			return ""
+		}
+
+		var body strings.Builder
+		next := 0
+		for i, token := range tokens {
+			if next >= len(newArgs) {
+				break
+			}
+			// Drop arguments fn removed; keep the rest with their leading whitespace
+			if len(newArgs)-next < len(tokens)-i && newArgs[next] != args[i] {
+				continue
+			}
+			body.WriteString(strings.TrimSuffix(token, args[i]) + newArgs[next])
+			next++
+		}
+		// Anything fn appended goes on the end
+		for ; next < len(newArgs); next++ {
+			body.WriteString(" " + newArgs[next])
+		}
+		trailing := argText[len(strings.TrimRightFunc(argText, unicode.IsSpace)):]
+		return indent + command + "(" + body.String() + trailing + ")" + newline
+	}
+
+	var result strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(content, -1) {
+		group := func(n int) string {
+			if loc[2*n] < 0 {
+				return ""
+			}
+			return content[loc[2*n]:loc[2*n+1]]
+		}
+		start := loc[0] + len(group(1))
+		lineStart := strings.LastIndex(content[:start], "\n") + 1
+		if cmakeCommentStart(content[lineStart:start]) >= 0 {
+			continue
+		}
+		result.WriteString(content[last:loc[0]])
+		result.WriteString(edit(group(1), group(2), group(3)))
+		last = loc[1]
+	}
+	result.WriteString(content[last:])
+	return result.String()
+}
+
+// removeLinkedLibrary drops a library from target_link_libraries arguments,
+// returning nil when nothing is left to link
+func removeLinkedLibrary(args []string, name string) []string {
+	if len(args) == 0 || args[0] == name {
+		return nil
+	}
+	result := []string{args[0]}
+	libraries := 0
+	for _, arg := range args[1:] {
+		if arg == name {
+			continue
+		}
+		if !isLinkKeyword(arg) {
+			libraries++
+		}
+		result = append(result, arg)
+	}
+	if libraries == 0 {
+		return nil
+	}
+	// Drop scope keywords that no longer apply to any library
+	cleaned := []string{result[0]}
+	for i := 1; i < len(result); i++ {
+		if isLinkKeyword(result[i]) && (i+1 == len(result) || isLinkKeyword(result[i+1])) {
+			continue
+		}
+		cleaned = append(cleaned, result[i])
+	}
+	return cleaned
+}
+
+func isLinkKeyword(arg string) bool {
+	return arg == "PRIVATE" || arg == "PUBLIC" || arg == "INTERFACE"
+}
+
+// removeInstalledTarget drops a target from install(TARGETS ...) arguments,
+// returning nil when no targets are left to install
+func removeInstalledTarget(args []string, name string) []string {
+	if len(args) == 0 || args[0] != "TARGETS" {
+		return args
+	}
+	result := []string{"TARGETS"}
+	targets := 0
+	inTargets := true
+	for _, arg := range args[1:] {
+		if inTargets && isInstallKeyword(arg) {
+			inTargets = false
+		}
+		if inTargets {
+			if arg == name {
+				continue
+			}
+			targets++
+		}
+		result = append(result, arg)
+	}
+	if targets == 0 {
+		return nil
+	}
+	return result
+}
+
+// renameInstalledTarget renames a target in install(TARGETS ...) arguments
+func renameInstalledTarget(args []string, oldName string, newName string) []string {
+	if len(args) == 0 || args[0] != "TARGETS" {
+		return args
+	}
+	for i := 1; i < len(args) && !isInstallKeyword(args[i]); i++ {
+		if args[i] == oldName {
+			args[i] = newName
+		}
+	}
+	return args
+}
+
+func isInstallKeyword(arg string) bool {
+	switch arg {
+	case "EXPORT", "RUNTIME_DEPENDENCIES", "RUNTIME_DEPENDENCY_SET", "ARCHIVE", "LIBRARY", "RUNTIME",
+		"OBJECTS", "FRAMEWORK", "BUNDLE", "PRIVATE_HEADER", "PUBLIC_HEADER", "RESOURCE", "FILE_SET",
+		"CXX_MODULES_BMI", "DESTINATION", "PERMISSIONS", "CONFIGURATIONS", "COMPONENT",
+		"NAMELINK_COMPONENT", "OPTIONAL", "EXCLUDE_FROM_ALL", "NAMELINK_ONLY", "NAMELINK_SKIP", "INCLUDES":
+		return true
+	}
+	return false
+}
+
+// reportReferences prints project files that still mention name, so
+// user-written references can be fixed by hand
+func reportReferences(name string) {
+	re := regexp.MustCompile(fmt.Sprintf(`(^|[^A-Za-z0-9_])%s([^A-Za-z0-9_]|$)`, regexp.QuoteMeta(name)))
+
+	var found []string
+	walkProjectFiles(".", func(path string) {
+		if !isCMakeFile(path) && !isSourceFile(path) {
+			return
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber++
+			line := scanner.Text()
+			if isCMakeFile(path) {
+				// Commented-out code is not a reference
+				if index := cmakeCommentStart(line); index >= 0 {
+					line = line[:index]
+				}
+			}
+			if re.MatchString(line) {
+				found = append(found, fmt.Sprintf("  %s:%d: %s", path, lineNumber, strings.TrimSpace(scanner.Text())))
+			}
+		}
+	})
+
+	if len(found) > 0 {
+		fmt.Printf("\nThe following lines still reference '%s' and may need manual updates:\n", name)
+		for _, line := range found {
+			fmt.Println(line)
+		}
+	}
+}
+
+// confirm asks a yes/no question on stdin and reports whether the answer was yes
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+	reader := bufio.NewReader(os.Stdin)
+	answer, err := reader.ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
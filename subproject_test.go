@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestEditCommandArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "library dropped from the call",
+			content: "target_link_libraries(app PRIVATE utils net)\n",
+			want:    "target_link_libraries(app PRIVATE net)\n",
+		},
+		{
+			name:    "call removed with its line",
+			content: "add_executable(app main.cpp)\n  target_link_libraries(app PRIVATE utils)\nmessage(done)\n",
+			want:    "add_executable(app main.cpp)\nmessage(done)\n",
+		},
+		{
+			name:    "multi-line call keeps its formatting",
+			content: "target_link_libraries(app\n    PRIVATE\n        utils\n        net\n)\n",
+			want:    "target_link_libraries(app\n    PRIVATE\n        net\n)\n",
+		},
+		{
+			name:    "commented-out call is left alone",
+			content: "# target_link_libraries(app PRIVATE utils)\ntarget_link_libraries(app PRIVATE utils net)\n",
+			want:    "# target_link_libraries(app PRIVATE utils)\ntarget_link_libraries(app PRIVATE net)\n",
+		},
+		{
+			name:    "call after a trailing comment is left alone",
+			content: "add_executable(app main.cpp) # target_link_libraries(app PRIVATE utils)\n",
+			want:    "add_executable(app main.cpp) # target_link_libraries(app PRIVATE utils)\n",
+		},
+		{
+			name:    "hash in a quoted argument is not a comment",
+			content: "message(\"#\") target_link_libraries(app PRIVATE utils net)\n",
+			want:    "message(\"#\") target_link_libraries(app PRIVATE net)\n",
+		},
+		{
+			name:    "other libraries are kept",
+			content: "target_link_libraries(app PRIVATE utils_extra)\n",
+			want:    "target_link_libraries(app PRIVATE utils_extra)\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := editCommandArgs(test.content, "target_link_libraries", func(args []string) []string {
+				return removeLinkedLibrary(args, "utils")
+			})
+			if got != test.want {
+				t.Errorf("editCommandArgs:\n got %q\nwant %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCMakeCommentStart(t *testing.T) {
+	tests := []struct {
+		line string
+		want int
+	}{
+		{"add_subdirectory(utils)", -1},
+		{"# add_subdirectory(utils)", 0},
+		{"  #add_subdirectory(utils)", 2},
+		{"add_subdirectory(utils) # keep", 24},
+		{`message("# not a comment")`, -1},
+		{`message("say \"#\"") # comment`, 21},
+	}
+
+	for _, test := range tests {
+		if got := cmakeCommentStart(test.line); got != test.want {
+			t.Errorf("cmakeCommentStart(%q) = %d, want %d", test.line, got, test.want)
+		}
+	}
+}
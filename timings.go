@@ -0,0 +1,457 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ninjaLogFile is where Ninja records the start and end of every build step
+const ninjaLogFile = ".ninja_log"
+
+// timingsLogFile is where 'qs __time' records build steps for generators
+// other than Ninja
+const timingsLogFile = ".qs-timings.log"
+
+// timingCommand is the hidden command CMake runs compilers and linkers
+// through to record their timings
+const timingCommand = "__time"
+
+// buildStep is a compile, link or other step of a build, with times in
+// microseconds
+type buildStep struct {
+	Output string
+	Start  int64
+	End    int64
+}
+
+func (s buildStep) duration() int64 {
+	return s.End - s.Start
+}
+
+// isCompile reports whether the step compiles a source file
+func (s buildStep) isCompile() bool {
+	return strings.HasSuffix(s.Output, ".o") || strings.HasSuffix(s.Output, ".obj")
+}
+
+// isNinjaGenerator reports whether a generator writes .ninja_log
+func isNinjaGenerator(generator string) bool {
+	return strings.HasPrefix(generator, "Ninja")
+}
+
+// timingWrapper returns the launcher prefix that records the timings of a
+// build directory built with a generator other than Ninja
+func timingWrapper(buildDir string) []string {
+	self, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+	return []string{self, timingCommand, filepath.Join(buildDir, timingsLogFile), "--"}
+}
+
+// runTimed implements 'qs __time <log> -- <command...>': it runs the command
+// and appends its output file and start and end time to the log. It exits
+// with the command's exit code.
+func runTimed(args []string) {
+	if len(args) < 3 || args[1] != "--" {
+		fmt.Fprintln(os.Stderr, "usage: qs __time <log> -- <command...>")
+		os.Exit(2)
+	}
+	logPath, command := args[0], args[2:]
+
+	start := time.Now()
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	err := cmd.Run()
+	end := time.Now()
+
+	output := ""
+	for i, arg := range command {
+		if arg == "-o" && i+1 < len(command) {
+			output = command[i+1]
+		} else if strings.HasPrefix(arg, "-o") && len(arg) > 2 && output == "" {
+			output = arg[2:]
+		}
+	}
+	if output != "" {
+		// Appends of a single short line do not interleave between parallel jobs
+		if file, err := os.OpenFile(logPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644); err == nil {
+			fmt.Fprintf(file, "%d\t%d\t%s\n", start.UnixMicro(), end.UnixMicro(), output)
+			file.Close()
+		}
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		os.Exit(exitErr.ExitCode())
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "qs: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// timingsMarkTail is how many bytes before the mark are kept to notice that
+// Ninja rewrote its log
+const timingsMarkTail = 256
+
+// errTimingsRewritten is returned when the log was rewritten during the build.
+// Ninja's times restart with every run, so the new steps cannot be told apart.
+var errTimingsRewritten = errors.New("Ninja compacted .ninja_log during the build, timings are unavailable for this build")
+
+// timingsMark remembers where the timings of a build start in its log
+type timingsMark struct {
+	Path   string
+	Offset int64
+	Tail   []byte // the bytes before Offset, which stay put unless the log is rewritten
+}
+
+// markTimings prepares the log of a build directory so the timings of the
+// build about to run can be told apart from earlier ones
+func markTimings(buildDir string, generator string) timingsMark {
+	if !isNinjaGenerator(generator) {
+		path := filepath.Join(buildDir, timingsLogFile)
+		os.Remove(path)
+		return timingsMark{Path: path}
+	}
+	path := filepath.Join(buildDir, ninjaLogFile)
+	mark := timingsMark{Path: path}
+	if data, err := os.ReadFile(path); err == nil {
+		mark.Offset = int64(len(data))
+		start := len(data) - timingsMarkTail
+		if start < 0 {
+			start = 0
+		}
+		mark.Tail = data[start:]
+	}
+	return mark
+}
+
+// readTimings reads the steps logged since the mark
+func readTimings(mark timingsMark) ([]buildStep, error) {
+	file, err := os.Open(mark.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Ninja rewrites the log from time to time, dropping old entries
+	if mark.Offset > 0 {
+		tail := make([]byte, len(mark.Tail))
+		if _, err := file.ReadAt(tail, mark.Offset-int64(len(tail))); err != nil || !bytes.Equal(tail, mark.Tail) {
+			return nil, errTimingsRewritten
+		}
+		if _, err := file.Seek(mark.Offset, 0); err != nil {
+			return nil, err
+		}
+	}
+	ninja := filepath.Base(mark.Path) == ninjaLogFile
+
+	steps := map[string]buildStep{}
+	seenEdges := map[string]bool{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		var step buildStep
+		var startErr, endErr error
+		if ninja {
+			// start, end, mtime, output, command hash; times in milliseconds
+			if len(fields) < 5 {
+				continue
+			}
+			step.Output = fields[3]
+			step.Start, startErr = strconv.ParseInt(fields[0], 10, 64)
+			step.End, endErr = strconv.ParseInt(fields[1], 10, 64)
+			step.Start *= 1000
+			step.End *= 1000
+			// A step with several outputs is logged once per output
+			edge := fields[0] + "\t" + fields[1] + "\t" + fields[4]
+			if seenEdges[edge] {
+				continue
+			}
+			seenEdges[edge] = true
+		} else {
+			// start, end, output; times in microseconds
+			if len(fields) < 3 {
+				continue
+			}
+			step.Output = fields[2]
+			step.Start, startErr = strconv.ParseInt(fields[0], 10, 64)
+			step.End, endErr = strconv.ParseInt(fields[1], 10, 64)
+		}
+		if startErr != nil || endErr != nil {
+			continue
+		}
+		steps[step.Output] = step
+	}
+
+	var result []buildStep
+	for _, step := range steps {
+		result = append(result, step)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start < result[j].Start
+		}
+		return result[i].Output < result[j].Output
+	})
+	return result, scanner.Err()
+}
+
+// stepTargetRegex finds the target of an object file, e.g. CMakeFiles/app.dir/
+var stepTargetRegex = regexp.MustCompile(`CMakeFiles/([^/]+)\.dir/`)
+
+// libraryNameRegex strips what platforms add to library and program names
+var libraryNameRegex = regexp.MustCompile(`^(lib)?(.+?)(\.(a|lib|so|dylib|dll|exe)(\.[0-9.]+)?)?$`)
+
+// stepTarget returns the CMake target a step belongs to: the target of an
+// object file, or the target a linked program or library is named after
+func stepTarget(step buildStep, targetNames map[string]bool) string {
+	output := filepath.ToSlash(step.Output)
+	if match := stepTargetRegex.FindStringSubmatch(output); match != nil {
+		return match[1]
+	}
+	base := path.Base(output)
+	if targetNames[base] {
+		return base
+	}
+	if match := libraryNameRegex.FindStringSubmatch(base); match != nil && targetNames[match[2]] {
+		return match[2]
+	}
+	return ""
+}
+
+// stepName shortens the output of a step for display, dropping the
+// CMakeFiles/<target>.dir/ part of object files
+func stepName(step buildStep) string {
+	output := filepath.ToSlash(step.Output)
+	if index := strings.Index(output, "CMakeFiles/"); index >= 0 {
+		if match := stepTargetRegex.FindStringIndex(output[index:]); match != nil {
+			return output[:index] + output[index+match[1]:]
+		}
+	}
+	return output
+}
+
+// criticalPath estimates the chain of steps that determined the build time.
+// Without the dependency graph, each step is assumed to have waited for the
+// step that finished last before it started.
+func criticalPath(steps []buildStep) []buildStep {
+	if len(steps) == 0 {
+		return nil
+	}
+	current := steps[0]
+	for _, step := range steps {
+		if step.End > current.End {
+			current = step
+		}
+	}
+
+	chain := []buildStep{current}
+	for {
+		found := false
+		var previous buildStep
+		for _, step := range steps {
+			if step.End <= current.Start && (!found || step.End > previous.End) {
+				previous, found = step, true
+			}
+		}
+		if !found {
+			break
+		}
+		chain = append([]buildStep{previous}, chain...)
+		current = previous
+	}
+	return chain
+}
+
+// seconds formats microseconds as seconds
+func seconds(micros int64) string {
+	return fmt.Sprintf("%.2fs", float64(micros)/1e6)
+}
+
+// printTimings prints the slowest compile and link steps, the time spent
+// per target and the estimated critical path
+func printTimings(steps []buildStep) {
+	if len(steps) == 0 {
+		fmt.Println("\nBuild timings: no build steps ran.")
+		return
+	}
+
+	targetNames := map[string]bool{}
+	if model, err := loadProject(); err == nil {
+		for _, name := range model.targetNames() {
+			targetNames[name] = true
+		}
+	}
+
+	first, last := steps[0].Start, steps[0].End
+	var cpu int64
+	var compiles, links []buildStep
+	for _, step := range steps {
+		if step.Start < first {
+			first = step.Start
+		}
+		if step.End > last {
+			last = step.End
+		}
+		cpu += step.duration()
+		if step.isCompile() {
+			compiles = append(compiles, step)
+		} else {
+			links = append(links, step)
+		}
+	}
+	wall := last - first
+	parallelism := 0.0
+	if wall > 0 {
+		parallelism = float64(cpu) / float64(wall)
+	}
+	fmt.Printf("\nBuild timings: %s, %s wall time, %s in steps (%.1fx parallel)\n",
+		plural(len(steps), "step"), seconds(wall), seconds(cpu), parallelism)
+
+	slowest := func(title string, list []buildStep) {
+		if len(list) == 0 {
+			return
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].duration() > list[j].duration() })
+		fmt.Printf("\n%s:\n", title)
+		for i, step := range list {
+			if i == 10 {
+				break
+			}
+			name := stepName(step)
+			if target := stepTarget(step, targetNames); target != "" && step.isCompile() {
+				name += " (" + target + ")"
+			}
+			fmt.Printf("  %8s  %s\n", seconds(step.duration()), name)
+		}
+	}
+	slowest("Slowest compile steps", compiles)
+	slowest("Slowest link steps", links)
+
+	perTarget := map[string]int64{}
+	stepCount := map[string]int{}
+	for _, step := range steps {
+		target := stepTarget(step, targetNames)
+		if target == "" {
+			target = "(other)"
+		}
+		perTarget[target] += step.duration()
+		stepCount[target]++
+	}
+	var names []string
+	for name := range perTarget {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return perTarget[names[i]] > perTarget[names[j]] })
+	fmt.Println("\nTime per target:")
+	for _, name := range names {
+		fmt.Printf("  %8s  %s (%s)\n", seconds(perTarget[name]), name, plural(stepCount[name], "step"))
+	}
+
+	chain := criticalPath(steps)
+	var chainTime int64
+	for _, step := range chain {
+		chainTime += step.duration()
+	}
+	fmt.Printf("\nCritical path (estimate): %s\n", seconds(chainTime))
+	for _, step := range chain {
+		fmt.Printf("  %8s  %s\n", seconds(step.duration()), stepName(step))
+	}
+}
+
+// writeChromeTrace writes the steps in the Chrome trace event format, for
+// chrome://tracing, Perfetto or speedscope. Steps that overlap go on
+// separate rows.
+func writeChromeTrace(steps []buildStep, tracePath string) error {
+	type traceEvent struct {
+		Name     string `json:"name"`
+		Category string `json:"cat"`
+		Phase    string `json:"ph"`
+		Time     int64  `json:"ts"`
+		Duration int64  `json:"dur"`
+		Process  int    `json:"pid"`
+		Thread   int    `json:"tid"`
+	}
+
+	events := []traceEvent{}
+	var laneEnds []int64
+	var first int64
+	if len(steps) > 0 {
+		first = steps[0].Start
+	}
+	for _, step := range steps {
+		lane := -1
+		for i, end := range laneEnds {
+			if end <= step.Start {
+				lane = i
+				break
+			}
+		}
+		if lane < 0 {
+			lane = len(laneEnds)
+			laneEnds = append(laneEnds, 0)
+		}
+		laneEnds[lane] = step.End
+
+		category := "link"
+		if step.isCompile() {
+			category = "compile"
+		}
+		events = append(events, traceEvent{
+			Name:     stepName(step),
+			Category: category,
+			Phase:    "X",
+			Time:     step.Start - first,
+			Duration: step.duration(),
+			Process:  1,
+			Thread:   lane + 1,
+		})
+	}
+
+	data, err := json.MarshalIndent(map[string]interface{}{"traceEvents": events, "displayTimeUnit": "ms"}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(tracePath, append(data, '\n'), 0644)
+}
+
+// reportTimings prints the timings of the build since the mark, and writes
+// them as a Chrome trace if tracePath is set
+func reportTimings(mark timingsMark, tracePath string) {
+	steps, err := readTimings(mark)
+	if err == errTimingsRewritten {
+		fmt.Printf("Note: %s\n", err)
+		return
+	} else if err != nil {
+		fmt.Printf("Error reading build timings: %s\n", err)
+		return
+	}
+	printTimings(steps)
+	if tracePath == "" {
+		return
+	}
+	if err := writeChromeTrace(steps, tracePath); err != nil {
+		fmt.Printf("Error writing trace: %s\n", err)
+		return
+	}
+	fmt.Printf("Trace written to %s, open it in chrome://tracing or https://ui.perfetto.dev\n", tracePath)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadTimings(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		log  string
+		want []buildStep
+	}{
+		{
+			name: "ninja log v5",
+			file: ninjaLogFile,
+			log: "# ninja log v5\n" +
+				"0\t120\t1700000000000000000\tCMakeFiles/app.dir/main.cpp.o\t3f2a\n" +
+				"5\t80\t1700000000000000000\tCMakeFiles/app.dir/util.cpp.o\t9b1c\n" +
+				"120\t150\t1700000000000000000\tapp\t77de\n",
+			want: []buildStep{
+				{Output: "CMakeFiles/app.dir/main.cpp.o", Start: 0, End: 120000},
+				{Output: "CMakeFiles/app.dir/util.cpp.o", Start: 5000, End: 80000},
+				{Output: "app", Start: 120000, End: 150000},
+			},
+		},
+		{
+			name: "step with several outputs is counted once",
+			file: ninjaLogFile,
+			log: "# ninja log v5\n" +
+				"10\t40\t0\tgen/a.h\tabcd\n" +
+				"10\t40\t0\tgen/a.cpp\tabcd\n",
+			want: []buildStep{
+				{Output: "gen/a.h", Start: 10000, End: 40000},
+			},
+		},
+		{
+			name: "newest entry of an output wins",
+			file: ninjaLogFile,
+			log: "# ninja log v5\n" +
+				"0\t100\t0\tmain.o\t1111\n" +
+				"200\t250\t0\tmain.o\t2222\n",
+			want: []buildStep{
+				{Output: "main.o", Start: 200000, End: 250000},
+			},
+		},
+		{
+			name: "malformed lines are skipped",
+			file: ninjaLogFile,
+			log: "# ninja log v5\n" +
+				"garbage\n" +
+				"x\t10\t0\tbad.o\t1111\n" +
+				"0\t10\t0\n" +
+				"0\t10\t0\tgood.o\t2222\n",
+			want: []buildStep{
+				{Output: "good.o", Start: 0, End: 10000},
+			},
+		},
+		{
+			name: "qs timing log",
+			file: timingsLogFile,
+			log: "1000\t5000\tCMakeFiles/app.dir/main.cpp.o\n" +
+				"5000\t7000\tbin/app\n" +
+				"bad line\n",
+			want: []buildStep{
+				{Output: "CMakeFiles/app.dir/main.cpp.o", Start: 1000, End: 5000},
+				{Output: "bin/app", Start: 5000, End: 7000},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), test.file)
+			if err := os.WriteFile(path, []byte(test.log), 0644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := readTimings(timingsMark{Path: path})
+			if err != nil {
+				t.Fatalf("readTimings: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readTimings:\n got %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestReadTimingsSinceMark(t *testing.T) {
+	earlier := "# ninja log v5\n0\t100\t0\told.o\t1111\n"
+	tests := []struct {
+		name    string
+		after   string // the log after the build
+		want    []buildStep
+		wantErr error
+	}{
+		{
+			name:  "appended steps",
+			after: earlier + "0\t30\t0\tnew.o\t2222\n",
+			want:  []buildStep{{Output: "new.o", Start: 0, End: 30000}},
+		},
+		{
+			name:  "nothing rebuilt",
+			after: earlier,
+			want:  nil,
+		},
+		{
+			name:    "compacted to a shorter log",
+			after:   "# ninja log v5\n0\t30\t0\tnew.o\t2222\n",
+			wantErr: errTimingsRewritten,
+		},
+		{
+			name:    "compacted to a longer log",
+			after:   "# ninja log v5\n0\t100\t0\tother.o\t3333\n0\t30\t0\tnew.o\t2222\n",
+			wantErr: errTimingsRewritten,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, ninjaLogFile)
+			if err := os.WriteFile(path, []byte(earlier), 0644); err != nil {
+				t.Fatal(err)
+			}
+			mark := markTimings(dir, "Ninja")
+			if err := os.WriteFile(path, []byte(test.after), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readTimings(mark)
+			if err != test.wantErr {
+				t.Fatalf("readTimings error %v, want %v", err, test.wantErr)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("readTimings:\n got %v\nwant %v", got, test.want)
+			}
+		})
+	}
+}
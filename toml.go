@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// tomlEntry is a key and its value from a TOML file, with the table it is in
+type tomlEntry struct {
+	Table string // dotted table name, empty for the top level
+	Key   string
+	Value interface{} // string, int64, bool or []interface{}
+	Line  int
+}
+
+// tomlParser reads the subset of TOML that qs settings use: tables, bare,
+// quoted and dotted keys, strings, integers, booleans and arrays
+type tomlParser struct {
+	file string
+	data string
+	pos  int
+	line int
+}
+
+// parseTOML parses a TOML document into its entries, in file order. Errors
+// name the file and line.
+func parseTOML(file string, data string) ([]tomlEntry, error) {
+	p := &tomlParser{file: file, data: strings.ReplaceAll(data, "\r\n", "\n"), line: 1}
+	var entries []tomlEntry
+	table := ""
+	seenTables := map[string]bool{}
+	seenKeys := map[string]bool{}
+
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.data) {
+			return entries, nil
+		}
+
+		if p.data[p.pos] == '[' {
+			if strings.HasPrefix(p.data[p.pos:], "[[") {
+				return nil, p.errorf("arrays of tables are not supported")
+			}
+			p.pos++
+			p.skipSpace()
+			parts, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if !p.consume(']') {
+				return nil, p.errorf("expected ']' after the table name")
+			}
+			table = strings.Join(parts, ".")
+			if seenTables[table] {
+				return nil, p.errorf("table [%s] is defined twice", table)
+			}
+			seenTables[table] = true
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		line := p.line
+		parts, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		if !p.consume('=') {
+			return nil, p.errorf("expected '=' after key '%s'", strings.Join(parts, "."))
+		}
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+
+		// A dotted key puts the value in a table below the current one
+		entry := tomlEntry{Table: table, Key: parts[len(parts)-1], Value: value, Line: line}
+		if len(parts) > 1 {
+			entry.Table = strings.Trim(table+"."+strings.Join(parts[:len(parts)-1], "."), ".")
+		}
+		full := entry.Table + "." + entry.Key
+		if seenKeys[full] {
+			return nil, fmt.Errorf("%s:%d: key '%s' is set twice", file, line, strings.TrimPrefix(full, "."))
+		}
+		seenKeys[full] = true
+		entries = append(entries, entry)
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s:%d: %s", p.file, p.line, fmt.Sprintf(format, args...))
+}
+
+func (p *tomlParser) consume(c byte) bool {
+	if p.pos < len(p.data) && p.data[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skipSpace skips spaces and tabs
+func (p *tomlParser) skipSpace() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace, line breaks and comments
+func (p *tomlParser) skipBlank() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine checks nothing but a comment follows on the line
+func (p *tomlParser) endOfLine() error {
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '#' {
+		for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+			p.pos++
+		}
+	}
+	if p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		return p.errorf("unexpected '%s' at the end of the line", strings.SplitN(p.data[p.pos:], "\n", 2)[0])
+	}
+	return nil
+}
+
+// parseKey reads a bare, quoted or dotted key
+func (p *tomlParser) parseKey() ([]string, error) {
+	var parts []string
+	for {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			return nil, p.errorf("expected a key")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '"' || c == '\'':
+			value, err := p.parseString()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, value)
+		case isBareKeyChar(c):
+			start := p.pos
+			for p.pos < len(p.data) && isBareKeyChar(p.data[p.pos]) {
+				p.pos++
+			}
+			parts = append(parts, p.data[start:p.pos])
+		default:
+			return nil, p.errorf("expected a key, found '%c'", c)
+		}
+		p.skipSpace()
+		if !p.consume('.') {
+			return parts, nil
+		}
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// parseValue reads a string, integer, boolean or array
+func (p *tomlParser) parseValue() (interface{}, error) {
+	if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+		return nil, p.errorf("expected a value")
+	}
+	switch p.data[p.pos] {
+	case '"', '\'':
+		return p.parseString()
+	case '[':
+		return p.parseArray()
+	case '{':
+		return nil, p.errorf("inline tables are not supported, use a [table] instead")
+	}
+
+	start := p.pos
+	for p.pos < len(p.data) && !strings.ContainsRune(" \t\n,]#", rune(p.data[p.pos])) {
+		p.pos++
+	}
+	token := p.data[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if n, err := strconv.ParseInt(strings.ReplaceAll(token, "_", ""), 10, 64); err == nil {
+		return n, nil
+	}
+	return nil, p.errorf("unsupported value '%s', expected a string, integer, boolean or array (strings need quotes)", token)
+}
+
+// parseString reads a basic "string" with escapes or a literal 'string'
+func (p *tomlParser) parseString() (string, error) {
+	quote := p.data[p.pos]
+	if strings.HasPrefix(p.data[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", p.errorf("multi-line strings are not supported")
+	}
+	end := p.pos + 1
+	for ; end < len(p.data) && p.data[end] != quote && p.data[end] != '\n'; end++ {
+		if quote == '"' && p.data[end] == '\\' {
+			end++
+		}
+	}
+	if end >= len(p.data) || p.data[end] != quote {
+		return "", p.errorf("unterminated string")
+	}
+	raw := p.data[p.pos+1 : end]
+	p.pos = end + 1
+	if quote == '\'' {
+		return raw, nil
+	}
+	return p.unescape(raw)
+}
+
+// tomlEscapes maps the single character escapes of basic strings
+var tomlEscapes = map[byte]byte{'b': '\b', 't': '\t', 'n': '\n', 'f': '\f', 'r': '\r', '"': '"', '\\': '\\'}
+
+// unescape decodes the escapes TOML allows in basic strings: \b \t \n \f \r
+// \" \\ \uXXXX and \UXXXXXXXX
+func (p *tomlParser) unescape(raw string) (string, error) {
+	var value strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			value.WriteByte(raw[i])
+			continue
+		}
+		i++
+		if c, ok := tomlEscapes[raw[i]]; ok {
+			value.WriteByte(c)
+			continue
+		}
+		digits := 0
+		switch raw[i] {
+		case 'u':
+			digits = 4
+		case 'U':
+			digits = 8
+		default:
+			return "", p.errorf("invalid escape '\\%c' in string", raw[i])
+		}
+		if i+digits >= len(raw) {
+			return "", p.errorf("'\\%c' needs %d hex digits", raw[i], digits)
+		}
+		code, err := strconv.ParseUint(raw[i+1:i+1+digits], 16, 32)
+		if err != nil || code > unicode.MaxRune || code >= 0xD800 && code <= 0xDFFF {
+			return "", p.errorf("invalid escape '\\%s' in string", raw[i:i+1+digits])
+		}
+		value.WriteRune(rune(code))
+		i += digits
+	}
+	return value.String(), nil
+}
+
+// parseArray reads an array, which may span several lines
+func (p *tomlParser) parseArray() ([]interface{}, error) {
+	p.pos++
+	values := []interface{}{}
+	for {
+		p.skipBlank()
+		if p.consume(']') {
+			return values, nil
+		}
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		p.skipBlank()
+		if p.consume(']') {
+			return values, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []tomlEntry
+	}{
+		{
+			name:  "empty",
+			input: "",
+			want:  nil,
+		},
+		{
+			name:  "top level key",
+			input: "key = \"value\"\n",
+			want:  []tomlEntry{{Table: "", Key: "key", Value: "value", Line: 1}},
+		},
+		{
+			name:  "sections",
+			input: "[build]\ndir = \"out\"\njobs = 8\n\n[run]\ntarget = \"app\"\n",
+			want: []tomlEntry{
+				{Table: "build", Key: "dir", Value: "out", Line: 2},
+				{Table: "build", Key: "jobs", Value: int64(8), Line: 3},
+				{Table: "run", Key: "target", Value: "app", Line: 6},
+			},
+		},
+		{
+			name:  "dotted profile table",
+			input: "[profile.gcc-debug]\ncompiler = \"gcc\"\n[profile.\"arm 64\"]\nconfig = 'Release'\n",
+			want: []tomlEntry{
+				{Table: "profile.gcc-debug", Key: "compiler", Value: "gcc", Line: 2},
+				{Table: "profile.arm 64", Key: "config", Value: "Release", Line: 4},
+			},
+		},
+		{
+			name:  "dotted keys",
+			input: "profile.asan.sanitize = \"address\"\n[build]\nx.y = true\n",
+			want: []tomlEntry{
+				{Table: "profile.asan", Key: "sanitize", Value: "address", Line: 1},
+				{Table: "build.x", Key: "y", Value: true, Line: 3},
+			},
+		},
+		{
+			name:  "booleans and integers",
+			input: "a = true\nb = false\nc = -3\nd = 1_000\n",
+			want: []tomlEntry{
+				{Key: "a", Value: true, Line: 1},
+				{Key: "b", Value: false, Line: 2},
+				{Key: "c", Value: int64(-3), Line: 3},
+				{Key: "d", Value: int64(1000), Line: 4},
+			},
+		},
+		{
+			name:  "arrays",
+			input: "empty = []\none = [\"a\"]\nmulti = [\n  \"address\", # comment\n  'undefined',\n]\nmixed = [1, true]\n",
+			want: []tomlEntry{
+				{Key: "empty", Value: []interface{}{}, Line: 1},
+				{Key: "one", Value: []interface{}{"a"}, Line: 2},
+				{Key: "multi", Value: []interface{}{"address", "undefined"}, Line: 3},
+				{Key: "mixed", Value: []interface{}{int64(1), true}, Line: 7},
+			},
+		},
+		{
+			name:  "comments",
+			input: "# leading comment\n[build] # table comment\n\n  # indented comment\ndir = \"out\" # trailing comment\nhash = \"a # b\"\n",
+			want: []tomlEntry{
+				{Table: "build", Key: "dir", Value: "out", Line: 5},
+				{Table: "build", Key: "hash", Value: "a # b", Line: 6},
+			},
+		},
+		{
+			name:  "escapes",
+			input: `s = "tab\there \"quoted\" back\\slash \u00e9 \U0001F600 \b\f\r\n"` + "\n" + `lit = 'C:\path\x41'` + "\n",
+			want: []tomlEntry{
+				{Key: "s", Value: "tab\there \"quoted\" back\\slash \u00e9 \U0001F600 \b\f\r\n", Line: 1},
+				{Key: "lit", Value: `C:\path\x41`, Line: 2},
+			},
+		},
+		{
+			name:  "CRLF line endings",
+			input: "[build]\r\njobs = 2\r\n",
+			want:  []tomlEntry{{Table: "build", Key: "jobs", Value: int64(2), Line: 2}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := parseTOML("qs.toml", test.input)
+			if err != nil {
+				t.Fatalf("parseTOML: %s", err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseTOML:\n got %#v\nwant %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTOMLErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string // start of the error, after the file name
+	}{
+		{"missing equals", "[build]\ndir \"out\"\n", "2: expected '=' after key 'dir'"},
+		{"missing value", "a = 1\nb =\n", "2: expected a value"},
+		{"unquoted string", "[build]\n\ngenerator = Ninja\n", "3: unsupported value 'Ninja'"},
+		{"unterminated string", "a = \"open\n", "1: unterminated string"},
+		{"trailing text", "a = 1 2\n", "1: unexpected '2' at the end of the line"},
+		{"unclosed table", "[build\n", "1: expected ']' after the table name"},
+		{"duplicate table", "[build]\n[run]\n[build]\n", "3: table [build] is defined twice"},
+		{"duplicate key", "[build]\njobs = 1\njobs = 2\n", "3: key 'build.jobs' is set twice"},
+		{"duplicate dotted key", "build.jobs = 1\n[build]\njobs = 2\n", "3: key 'build.jobs' is set twice"},
+		{"array of tables", "[[profile]]\n", "1: arrays of tables are not supported"},
+		{"inline table", "a = 1\nb = { c = 1 }\n", "2: inline tables are not supported"},
+		{"multi-line string", "a = \"\"\"\ntext\"\"\"\n", "1: multi-line strings are not supported"},
+		{"unclosed array", "a = [1, 2\nb = 3\n", "2: expected ',' or ']' in array"},
+		{"go hex escape", "a = 1\ns = \"\\x41\"\n", "2: invalid escape '\\x' in string"},
+		{"go bell escape", "s = \"\\a\"\n", "1: invalid escape '\\a' in string"},
+		{"short unicode escape", "s = \"\\u12\"\n", "1: '\\u' needs 4 hex digits"},
+		{"bad unicode escape", "s = \"\\uZZZZ\"\n", "1: invalid escape '\\uZZZZ' in string"},
+		{"surrogate escape", "s = \"\\uD800\"\n", "1: invalid escape '\\uD800' in string"},
+		{"bad key", "= 1\n", "1: expected a key, found '='"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := parseTOML("qs.toml", test.input)
+			if err == nil {
+				t.Fatalf("parseTOML succeeded, want error %q", test.want)
+			}
+			if want := "qs.toml:" + test.want; !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("parseTOML error %q, want %q", err, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// toolchainDir holds the toolchain files written by 'qs toolchain new'
+const toolchainDir = "cmake/toolchains"
+
+// toolchainNameRegex matches the names accepted for toolchains, which are
+// also used in build directory names
+var toolchainNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// toolchainOptions holds the arguments of 'qs toolchain new'
+type toolchainOptions struct {
+	Name       string
+	CC         string // C compiler
+	CXX        string // C++ compiler
+	Sysroot    string
+	SystemName string // CMAKE_SYSTEM_NAME, e.g. "Linux"
+	Processor  string // CMAKE_SYSTEM_PROCESSOR, e.g. "aarch64"
+	Force      bool   // overwrite an existing toolchain file
+}
+
+// parseToolchainArgs parses the arguments following 'qs toolchain new'
+func parseToolchainArgs(args []string) (toolchainOptions, error) {
+	opts := toolchainOptions{SystemName: "Linux"}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		name, value := arg, ""
+		if strings.HasPrefix(arg, "--") && strings.Contains(arg, "=") {
+			parts := strings.SplitN(arg, "=", 2)
+			name, value = parts[0], parts[1]
+		}
+
+		var field *string
+		switch name {
+		case "--cc":
+			field = &opts.CC
+		case "--cxx":
+			field = &opts.CXX
+		case "--sysroot":
+			field = &opts.Sysroot
+		case "--system-name":
+			field = &opts.SystemName
+		case "--processor":
+			field = &opts.Processor
+		case "--force":
+			opts.Force = true
+			continue
+		default:
+			if strings.HasPrefix(arg, "-") {
+				return opts, fmt.Errorf("unknown option '%s'", arg)
+			}
+			if opts.Name != "" {
+				return opts, fmt.Errorf("unexpected argument '%s'", arg)
+			}
+			opts.Name = arg
+			continue
+		}
+
+		if value == "" {
+			if i+1 >= len(args) {
+				return opts, fmt.Errorf("'%s' requires a value", name)
+			}
+			i++
+			value = args[i]
+		}
+		*field = value
+	}
+
+	if opts.Name == "" {
+		return opts, fmt.Errorf("'toolchain new' requires a name")
+	}
+	if !toolchainNameRegex.MatchString(opts.Name) {
+		return opts, fmt.Errorf("invalid toolchain name '%s' (use letters, digits, '.', '_' and '-')", opts.Name)
+	}
+	if opts.CC == "" && opts.CXX == "" {
+		return opts, fmt.Errorf("give the cross compilers with --cc and/or --cxx")
+	}
+	if opts.Processor == "" {
+		opts.Processor = targetProcessor(opts.CXX, opts.CC)
+	}
+	return opts, nil
+}
+
+// targetProcessor guesses the target processor from a compiler named after
+// its target triple, e.g. "aarch64" for aarch64-linux-gnu-g++
+func targetProcessor(compilers ...string) string {
+	for _, compiler := range compilers {
+		parts := strings.Split(filepath.Base(compiler), "-")
+		if len(parts) >= 3 {
+			return parts[0]
+		}
+	}
+	return ""
+}
+
+// toolchainFile returns the path of a toolchain, given by name or by the path
+// of its file
+func toolchainFile(name string) string {
+	if strings.HasSuffix(name, ".cmake") || strings.ContainsAny(name, `/\`) {
+		return name
+	}
+	return filepath.Join(toolchainDir, name+".cmake")
+}
+
+// toolchainName returns the name used in build directories for a toolchain
+// given by name or by the path of its file
+func toolchainName(toolchain string) string {
+	return strings.TrimSuffix(filepath.Base(toolchain), ".cmake")
+}
+
+// renderToolchainFile returns the contents of a CMake toolchain file
+func renderToolchainFile(opts toolchainOptions) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Toolchain '%s', generated by qs toolchain new\n", opts.Name)
+	fmt.Fprintf(&b, "# Use it with: qs build --toolchain %s\n\n", opts.Name)
+	fmt.Fprintf(&b, "set(CMAKE_SYSTEM_NAME %s)\n", opts.SystemName)
+	if opts.Processor != "" {
+		fmt.Fprintf(&b, "set(CMAKE_SYSTEM_PROCESSOR %s)\n", opts.Processor)
+	}
+	b.WriteString("\n")
+	if opts.CC != "" {
+		fmt.Fprintf(&b, "set(CMAKE_C_COMPILER %s)\n", opts.CC)
+	}
+	if opts.CXX != "" {
+		fmt.Fprintf(&b, "set(CMAKE_CXX_COMPILER %s)\n", opts.CXX)
+	}
+
+	if opts.Sysroot != "" {
+		fmt.Fprintf(&b, "\nset(CMAKE_SYSROOT %s)\n", filepath.ToSlash(opts.Sysroot))
+		b.WriteString("set(CMAKE_FIND_ROOT_PATH ${CMAKE_SYSROOT})\n")
+	}
+	b.WriteString("\n# Find programs on the host, libraries and headers for the target\n")
+	b.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_PROGRAM NEVER)\n")
+	b.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_LIBRARY ONLY)\n")
+	b.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_INCLUDE ONLY)\n")
+	b.WriteString("set(CMAKE_FIND_ROOT_PATH_MODE_PACKAGE ONLY)\n")
+	return b.String()
+}
+
+// newToolchain writes a toolchain file to cmake/toolchains/<name>.cmake
+func newToolchain(opts toolchainOptions) {
+	path := toolchainFile(opts.Name)
+	if fileExists(path) && !opts.Force {
+		fmt.Printf("Error: %s already exists, use --force to overwrite it\n", path)
+		return
+	}
+
+	for _, compiler := range []string{opts.CC, opts.CXX} {
+		if compiler == "" {
+			continue
+		}
+		if _, err := exec.LookPath(compiler); err != nil {
+			fmt.Printf("Warning: compiler '%s' not found\n", compiler)
+		}
+	}
+	if opts.Sysroot != "" {
+		if abs, err := filepath.Abs(opts.Sysroot); err == nil {
+			opts.Sysroot = abs
+		}
+		if !isDir(opts.Sysroot) {
+			fmt.Printf("Warning: sysroot '%s' does not exist\n", opts.Sysroot)
+		}
+	}
+
+	if err := os.MkdirAll(toolchainDir, 0755); err != nil {
+		fmt.Printf("Error creating %s: %s\n", toolchainDir, err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(renderToolchainFile(opts)), 0644); err != nil {
+		fmt.Printf("Error writing %s: %s\n", path, err)
+		return
+	}
+	fmt.Printf("Created %s\n", path)
+	fmt.Printf("Build with it using 'qs build --toolchain %s'\n", opts.Name)
+}
+
+// listToolchains prints the toolchain files in cmake/toolchains
+func listToolchains() {
+	files, _ := filepath.Glob(filepath.Join(toolchainDir, "*.cmake"))
+	if len(files) == 0 {
+		fmt.Println("No toolchains found. Create one with 'qs toolchain new <name> --cxx <compiler>'.")
+		return
+	}
+	fmt.Println("Toolchains:")
+	for _, file := range files {
+		processor := readToolchainSetting(file, "CMAKE_SYSTEM_PROCESSOR")
+		compiler := readToolchainSetting(file, "CMAKE_CXX_COMPILER")
+		if compiler == "" {
+			compiler = readToolchainSetting(file, "CMAKE_C_COMPILER")
+		}
+		fmt.Printf("  %-16s %-10s %s\n", toolchainName(file), processor, compiler)
+	}
+}
+
+// readToolchainSetting returns the value of a set(NAME value) call in a
+// toolchain file
+func readToolchainSetting(path string, name string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	re := regexp.MustCompile(`(?m)^\s*set\s*\(\s*` + regexp.QuoteMeta(name) + `\s+"?([^")]*)"?\s*\)`)
+	if match := re.FindStringSubmatch(string(data)); match != nil {
+		return strings.TrimSpace(match[1])
+	}
+	return ""
+}
+
+// toolchainCommand implements 'qs toolchain new|list'
+func toolchainCommand(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Error: usage is 'qs toolchain new <name> --cc <compiler> --cxx <compiler> ...' or 'qs toolchain list'")
+		return
+	}
+	switch args[0] {
+	case "new":
+		opts, err := parseToolchainArgs(args[1:])
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		newToolchain(opts)
+	case "list":
+		listToolchains()
+	default:
+		fmt.Printf("Error: unknown toolchain command '%s' (expected new or list)\n", args[0])
+	}
+}
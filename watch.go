@@ -0,0 +1,358 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// watchDebounce is how long the project has to be quiet after a change before
+// qs rebuilds, so saving several files or switching branches builds once
+const watchDebounce = 300 * time.Millisecond
+
+// runStopTimeout is how long a program restarted by 'qs run --watch' gets to
+// exit after SIGTERM before it is killed
+const runStopTimeout = 5 * time.Second
+
+// watchPollInterval is how often the polling watcher scans the project
+const watchPollInterval = 500 * time.Millisecond
+
+// fileWatcher reports changes to the project files a build depends on
+type fileWatcher interface {
+	// Changes delivers the paths of created, modified and removed files
+	Changes() <-chan string
+	Close()
+}
+
+// isWatchedFile reports whether a change to the file can affect the build:
+// sources, headers and CMake files. Editor swap, backup and lock files are
+// left out.
+func isWatchedFile(path string) bool {
+	name := filepath.Base(path)
+	if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "#") || strings.HasSuffix(name, "~") {
+		return false
+	}
+	return isSourceFile(name) || isCMakeFile(name)
+}
+
+// fileStamp is what the polling watcher compares to notice a change
+type fileStamp struct {
+	ModTime time.Time
+	Size    int64
+}
+
+// pollingWatcher scans the project for changes at an interval, where the
+// platform has no file notifications qs can use
+type pollingWatcher struct {
+	changes chan string
+	done    chan struct{}
+}
+
+func newPollingWatcher(root string) *pollingWatcher {
+	w := &pollingWatcher{changes: make(chan string, 256), done: make(chan struct{})}
+	go w.run(root)
+	return w
+}
+
+func (w *pollingWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *pollingWatcher) Close() {
+	close(w.done)
+}
+
+func (w *pollingWatcher) run(root string) {
+	previous := scanWatchedFiles(root)
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		}
+
+		current := scanWatchedFiles(root)
+		for path, stamp := range current {
+			if old, ok := previous[path]; !ok || old != stamp {
+				if !w.send(path) {
+					return
+				}
+			}
+		}
+		for path := range previous {
+			if _, ok := current[path]; !ok {
+				if !w.send(path) {
+					return
+				}
+			}
+		}
+		previous = current
+	}
+}
+
+// send reports a change, or returns false once the watcher is closed
+func (w *pollingWatcher) send(path string) bool {
+	select {
+	case w.changes <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// scanWatchedFiles stamps every watched file of the project
+func scanWatchedFiles(root string) map[string]fileStamp {
+	stamps := map[string]fileStamp{}
+	walkProjectFiles(root, func(path string) {
+		if !isWatchedFile(path) {
+			return
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamps[path] = fileStamp{info.ModTime(), info.Size()}
+		}
+	})
+	return stamps
+}
+
+// waitForChanges blocks until a file changes, then collects further changes
+// until the project has been quiet for watchDebounce, and returns the changed
+// paths sorted
+func waitForChanges(changes <-chan string) []string {
+	return debounceChanges(<-changes, changes)
+}
+
+// debounceChanges collects changes after the first one until the project has
+// been quiet for watchDebounce, and returns the changed paths sorted
+func debounceChanges(first string, changes <-chan string) []string {
+	changed := map[string]bool{first: true}
+	timer := time.NewTimer(watchDebounce)
+	defer timer.Stop()
+	for {
+		select {
+		case path := <-changes:
+			changed[path] = true
+			timer.Reset(watchDebounce)
+		case <-timer.C:
+			var paths []string
+			for path := range changed {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			return paths
+		}
+	}
+}
+
+// describeChanges names the changed files for a status line
+func describeChanges(paths []string) string {
+	switch len(paths) {
+	case 0:
+		return ""
+	case 1:
+		return filepath.ToSlash(paths[0]) + " changed"
+	case 2:
+		return filepath.ToSlash(paths[0]) + " and " + filepath.ToSlash(paths[1]) + " changed"
+	}
+	return fmt.Sprintf("%s and %d other files changed", filepath.ToSlash(paths[0]), len(paths)-1)
+}
+
+// printWatchStatus prints the one line summary of a build cycle
+func printWatchStatus(ok bool, elapsed time.Duration, changed []string) {
+	result := "Build passed"
+	if !ok {
+		result = "Build FAILED"
+	}
+	line := fmt.Sprintf("[%s] %s in %s", time.Now().Format("15:04:05"), result, seconds(elapsed.Microseconds()))
+	if description := describeChanges(changed); description != "" {
+		line += " (" + description + ")"
+	}
+	fmt.Println(line)
+}
+
+// watchProject builds the project, then rebuilds it every time a source,
+// header or CMake file changes until interrupted. The build itself only
+// recompiles what the changes made out of date.
+func watchProject(opts buildOptions) {
+	if _, err := os.Stat("CMakeLists.txt"); os.IsNotExist(err) {
+		fmt.Println("Error: CMakeLists.txt not found in the current directory.")
+		fmt.Println("Run 'qs init' to create a new CMake project.")
+		return
+	}
+	warnUnknownTargets(opts.Targets)
+
+	watcher := newFileWatcher(".")
+	defer watcher.Close()
+
+	var changed []string
+	for {
+		start := time.Now()
+		ok := buildProject(opts)
+		printWatchStatus(ok, time.Since(start), changed)
+		fmt.Println("Watching for changes, press Ctrl+C to stop...")
+		changed = waitForChanges(watcher.Changes())
+		fmt.Println()
+	}
+}
+
+// runningProgram is a program started by 'qs run --watch'
+type runningProgram struct {
+	name string
+	cmd  *exec.Cmd
+	done chan struct{} // closed when the program has exited
+	err  error
+}
+
+// startProgram starts an executable of a build directory. It shares the
+// terminal with qs, so interactive programs keep reading stdin.
+func startProgram(path string, buildDir string) (*runningProgram, error) {
+	cmd := exec.Command(path)
+	cmd.Env = sanitizerEnv(buildDir)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Stdin = os.Stdin
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	p := &runningProgram{name: filepath.Base(path), cmd: cmd, done: make(chan struct{})}
+	go func() {
+		p.err = cmd.Wait()
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// exitStatus describes how the program exited
+func (p *runningProgram) exitStatus() string {
+	var exitErr *exec.ExitError
+	if errors.As(p.err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return "was killed by " + status.Signal().String()
+		}
+		return fmt.Sprintf("exited with status %d", exitErr.ExitCode())
+	}
+	if p.err != nil {
+		return "failed: " + p.err.Error()
+	}
+	return "exited"
+}
+
+// stop asks the program to exit with SIGTERM and kills it if it is still
+// running after runStopTimeout
+func (p *runningProgram) stop() {
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+
+	fmt.Printf("Stopping %s...\n", p.name)
+	if err := p.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// Windows cannot deliver SIGTERM
+		p.cmd.Process.Kill()
+	}
+	select {
+	case <-p.done:
+	case <-time.After(runStopTimeout):
+		fmt.Printf("%s did not exit within %s, killing it.\n", p.name, runStopTimeout)
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// runWatch builds and runs an executable target, then rebuilds and restarts
+// it whenever a source, header or CMake file changes. A failed build leaves
+// the previous version running.
+func runWatch(opts buildOptions) {
+	if _, err := os.Stat("CMakeLists.txt"); os.IsNotExist(err) {
+		fmt.Println("Error: CMakeLists.txt not found in the current directory.")
+		fmt.Println("Run 'qs init' to create a new CMake project.")
+		return
+	}
+	if len(opts.Targets) > 1 {
+		fmt.Println("Error: 'qs run --watch' runs a single target")
+		return
+	}
+	if len(opts.Targets) == 0 && settings.Run.Target != "" {
+		opts.Targets = []string{settings.Run.Target}
+	}
+	warnUnknownTargets(opts.Targets)
+	targetName := ""
+	if len(opts.Targets) == 1 {
+		targetName = opts.Targets[0]
+	}
+
+	watcher := newFileWatcher(".")
+	defer watcher.Close()
+
+	// Stop the program with qs, also when it ignores the interrupt itself
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupts)
+
+	var program *runningProgram
+	var exited chan struct{}
+	var changed []string
+	for {
+		// Windows cannot replace the executable of a running program
+		if program != nil && runtime.GOOS == "windows" {
+			program.stop()
+		}
+
+		start := time.Now()
+		ok := buildProject(opts)
+		printWatchStatus(ok, time.Since(start), changed)
+		if ok {
+			if buildDir, err := selectBuildDir(buildOptions{}); err != nil {
+				fmt.Printf("Error: %s\n", err)
+			} else if path, found := findRunTarget(targetName, buildDir); found {
+				if program != nil {
+					program.stop()
+				}
+				fmt.Printf("Running %s...\n", filepath.Base(path))
+				program, err = startProgram(path, buildDir)
+				if err != nil {
+					fmt.Printf("Error running target: %s\n", err)
+				}
+			}
+		} else if program != nil {
+			select {
+			case <-program.done:
+			default:
+				fmt.Printf("Keeping the previous %s running.\n", program.name)
+			}
+		}
+		exited = nil
+		if program != nil {
+			exited = program.done
+		}
+		fmt.Println("Watching for changes, press Ctrl+C to stop...")
+
+		changed = nil
+		for changed == nil {
+			select {
+			case path := <-watcher.Changes():
+				changed = debounceChanges(path, watcher.Changes())
+			case <-exited:
+				fmt.Printf("[%s] %s %s\n", time.Now().Format("15:04:05"), program.name, program.exitStatus())
+				exited = nil
+			case <-interrupts:
+				if program != nil {
+					program.stop()
+				}
+				return
+			}
+		}
+		fmt.Println()
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// inotifyMask selects the events that mean a file was written, created,
+// removed or renamed
+const inotifyMask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+
+// inotifyWatcher watches every project directory with inotify
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File       // fd, read through the runtime poller so Close stops a pending read
+	dirs    map[int]string // watch descriptor to directory
+	changes chan string
+	done    chan struct{}
+}
+
+// newFileWatcher watches the project with inotify, or polls it when inotify
+// is unavailable, e.g. because the limit on watches is reached
+func newFileWatcher(root string) fileWatcher {
+	w, err := newInotifyWatcher(root)
+	if err != nil {
+		fmt.Printf("Note: cannot use inotify (%s), polling for changes instead.\n", err)
+		return newPollingWatcher(root)
+	}
+	return w
+}
+
+func newInotifyWatcher(root string) (*inotifyWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dirs:    map[int]string{},
+		changes: make(chan string, 256),
+		done:    make(chan struct{}),
+	}
+	if _, err := w.addTree(root, true); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() {
+	close(w.done)
+	w.file.Close()
+}
+
+// send reports a change, or returns false once the watcher is closed
+func (w *inotifyWatcher) send(path string) bool {
+	select {
+	case w.changes <- path:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// addTree watches a directory and its project subdirectories, and returns
+// the watched files found in them
+func (w *inotifyWatcher) addTree(root string, isRoot bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			// Removed again before it could be watched
+			return nil
+		}
+		if !d.IsDir() {
+			if isWatchedFile(path) {
+				files = append(files, path)
+			}
+			return nil
+		}
+		if (path != root || !isRoot) && isIgnoredDir(path) {
+			return filepath.SkipDir
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			if err == syscall.ENOSPC {
+				return fmt.Errorf("too many directories to watch, raise fs.inotify.max_user_watches")
+			}
+			return err
+		}
+		w.dirs[wd] = path
+		return nil
+	})
+	return files, err
+}
+
+// run reads events until the watcher is closed
+func (w *inotifyWatcher) run() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil || n <= 0 {
+			return
+		}
+
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, int(event.Wd))
+				continue
+			}
+			dir, ok := w.dirs[int(event.Wd)]
+			if !ok || name == "" {
+				continue
+			}
+			path := filepath.Join(dir, name)
+
+			if event.Mask&syscall.IN_ISDIR != 0 {
+				// A new or renamed directory may arrive with sources in it
+				if event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 && !isIgnoredDir(path) {
+					files, _ := w.addTree(path, false)
+					for _, file := range files {
+						if !w.send(file) {
+							return
+						}
+					}
+				}
+				continue
+			}
+			if isWatchedFile(path) && !w.send(path) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package main
+
+// newFileWatcher polls the project for changes
+func newFileWatcher(root string) fileWatcher {
+	return newPollingWatcher(root)
+}